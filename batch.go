@@ -0,0 +1,229 @@
+package tsz
+
+import (
+	"io"
+	"math"
+	"math/bits"
+)
+
+// NextBatch decodes the next points of the series into ts and vs, returning
+// how many were decoded.  It decodes up to the length of the shorter slice,
+// returning fewer only at the end of the series or on an error, reported by
+// Err.  Timestamps are truncated to 32 bits as by Values.
+//
+// Only series of the XOR codec, with or without integer runs, are decoded in
+// a loop of their own.  The points of the other codecs are read by Next one
+// at a time, so batching them saves no more than the calls.
+func (it *Iter) NextBatch(ts []uint32, vs []float64) int {
+	n := len(ts)
+	if len(vs) < n {
+		n = len(vs)
+	}
+	ts, vs = ts[:n], vs[:n]
+
+	for i := 0; i < n; i++ {
+		if i += it.nextXOR(ts[i:], nil, vs[i:]); i == n {
+			break
+		}
+		if !it.Next() {
+			return i
+		}
+		ts[i], vs[i] = uint32(it.t), it.value()
+	}
+	return n
+}
+
+// NextBatch64 is NextBatch with full 64-bit timestamps, as returned by
+// Values64
+func (it *Iter) NextBatch64(ts []int64, vs []float64) int {
+	n := len(ts)
+	if len(vs) < n {
+		n = len(vs)
+	}
+	ts, vs = ts[:n], vs[:n]
+
+	mask := int64(-1)
+	if !it.fmt.wide {
+		mask = 1<<32 - 1
+	}
+
+	for i := 0; i < n; i++ {
+		if i += it.nextXOR(nil, ts[i:], vs[i:]); i == n {
+			break
+		}
+		if !it.Next() {
+			return i
+		}
+		ts[i], vs[i] = it.t&mask, it.value()
+	}
+	return n
+}
+
+// nextXOR decodes the next points of an XOR series into vs, and their
+// timestamps into ts32 or ts64, returning how many it decoded.  It does the
+// work of Next in a single loop, reading the control bits and windows of each
+// point straight from the accumulator of the reader, for as long as the
+// points take the common paths of the format.  It stops before anything else,
+// such as a '1111' delta-of-delta, the end of the stream, an integer run or a
+// null point, and leaves it to Next.
+func (it *Iter) nextXOR(ts32 []uint32, ts64 []int64, vs []float64) int {
+	switch {
+	case it.err != nil, it.finished, it.n == 0, it.n < it.fmt.learn:
+		return 0
+	case it.fmt.codec != codecXOR && it.fmt.codec != codecXORInt:
+		return 0
+	}
+
+	br := &it.br
+	dods := &it.ts.dod
+	leadingBits := uint(it.fmt.leadingBits())
+	runs := it.fmt.codec == codecXORInt
+	t, tDelta, val := it.t, it.tDelta, math.Float64bits(it.val)
+	leading, trailing := it.leading, it.trailing
+
+	// the most bits a point takes before its meaningful bits
+	need := 4 + uint(dods[2]) + 2 + leadingBits + 6
+	if runs {
+		need++
+	}
+
+	var n int
+	for ; n < len(vs); n++ {
+		if br.count < need {
+			br.refill()
+			if br.count < need {
+				// the last few bytes of the stream
+				break
+			}
+		}
+		acc, count := br.acc, br.count
+
+		// delta-of-delta
+		d := uint(bits.LeadingZeros64(^acc))
+		if d >= 4 {
+			break
+		}
+		acc <<= d + 1
+		count -= d + 1
+		var dod int64
+		if d > 0 {
+			sz := uint(dods[d-1])
+			u := acc >> (64 - sz)
+			acc <<= sz
+			count -= sz
+			if u > 1<<(sz-1) {
+				u -= 1 << sz
+			}
+			dod = int64(u)
+		}
+
+		// value
+		if acc>>63 == 0 {
+			acc <<= 1
+			count--
+		} else {
+			l, tr := leading, trailing
+			if acc>>62&1 == 0 {
+				if l == ^uint8(0) {
+					break
+				}
+				acc <<= 2
+				count -= 2
+			} else {
+				acc <<= 2
+				count -= 2
+				if runs {
+					if acc>>63 != 0 {
+						break
+					}
+					acc <<= 1
+					count--
+				}
+				lb := uint8(acc >> (64 - leadingBits))
+				acc <<= leadingBits
+				mb := uint8(acc >> 58)
+				acc <<= 6
+				count -= leadingBits + 6
+				if it.fmt.isNullWindow(lb, mb) {
+					break
+				}
+				if mb == 0 {
+					mb = 64
+				}
+				if int(lb)+int(mb) > 64 {
+					break
+				}
+				l, tr = lb, 64-lb-mb
+			}
+
+			m := uint(64 - l - tr)
+			var u uint64
+			if m <= count {
+				u = acc >> (64 - m)
+				acc <<= m
+				count -= m
+			} else {
+				i, acc0, count0 := br.i, br.acc, br.count
+				br.acc, br.count = acc, count
+				var err error
+				if u, err = br.readBits(int(m)); err != nil {
+					// Next reads the point again from its start
+					br.i, br.acc, br.count = i, acc0, count0
+					break
+				}
+				acc, count = br.acc, br.count
+			}
+			val ^= u << tr
+			leading, trailing = l, tr
+		}
+		br.acc, br.count = acc, count
+
+		tDelta += dod
+		t += tDelta
+		if !it.fmt.wide {
+			tDelta = int64(uint32(tDelta))
+			t = int64(uint32(t))
+		}
+		if ts32 != nil {
+			ts32[n] = uint32(t)
+		} else {
+			ts64[n] = t
+		}
+		vs[n] = math.Float64frombits(val)
+	}
+
+	if n > 0 {
+		it.t, it.tDelta, it.val = t, tDelta, math.Float64frombits(val)
+		it.leading, it.trailing = leading, trailing
+		it.null = false
+		it.n += n
+	}
+	return n
+}
+
+// DecodeAll decodes the finished block b into ts and vs, returning the number
+// of points decoded.  It returns io.ErrShortBuffer if the block holds more
+// points than the shorter slice has room for, and io.ErrUnexpectedEOF if the
+// block has no end-of-stream record.  Blocks without a header must have
+// 32-bit timestamps.  Points are decoded by NextBatch, and only as fast as it
+// decodes them for the codec of the block.
+func DecodeAll(b []byte, ts []uint32, vs []float64) (int, error) {
+	it, err := NewIterator(b)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	n := it.NextBatch(ts, vs)
+	if it.err == nil && !it.finished && it.Next() {
+		return n, io.ErrShortBuffer
+	}
+
+	err = it.Err()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+package tsz
+
+import (
+	"io"
+	"math"
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+func TestDecodeAll(t *testing.T) {
+	data := testdata.TwoHoursData
+	for _, opts := range [][]Option{nil, {WithChecksum()}, {WithIndex(10)}} {
+		b := indexedBlock(opts...)
+
+		ts := make([]uint32, len(data)+5)
+		vs := make([]float64, len(data)+5)
+		n, err := DecodeAll(b, ts, vs)
+		if n != len(data) || err != nil {
+			t.Fatalf("DecodeAll()=(%d,%v), want (%d,nil)", n, err, len(data))
+		}
+		for i, p := range data {
+			if ts[i] != p.T || vs[i] != p.V {
+				t.Errorf("point %d=(%v,%v), want (%v,%v)", i, ts[i], vs[i], p.T, p.V)
+			}
+		}
+
+		// exactly the right size
+		if n, err := DecodeAll(b, ts[:len(data)], vs); n != len(data) || err != nil {
+			t.Errorf("DecodeAll(exact)=(%d,%v), want (%d,nil)", n, err, len(data))
+		}
+
+		if n, err := DecodeAll(b, ts, vs[:10]); n != 10 || err != io.ErrShortBuffer {
+			t.Errorf("DecodeAll(short)=(%d,%v), want (10,%v)", n, err, io.ErrShortBuffer)
+		}
+	}
+}
+
+func TestDecodeAllErrors(t *testing.T) {
+	ts := make([]uint32, 200)
+	vs := make([]float64, 200)
+
+	if _, err := DecodeAll(nil, ts, vs); err != io.ErrUnexpectedEOF {
+		t.Errorf("DecodeAll(nil)=%v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	b := indexedBlock(WithChecksum())
+	if _, err := DecodeAll(b[:len(b)/2], ts, vs); err != io.ErrUnexpectedEOF {
+		t.Errorf("DecodeAll(truncated)=%v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	b[len(b)-1] ^= 1
+	if _, err := DecodeAll(b, ts, vs); err != ErrChecksum {
+		t.Errorf("DecodeAll(bad checksum)=%v, want %v", err, ErrChecksum)
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	data := testdata.TwoHoursData
+	it, _ := NewIterator(indexedBlock())
+
+	ts := make([]uint32, 7)
+	vs := make([]float64, 7)
+	var got int
+	for {
+		n := it.NextBatch(ts, vs)
+		for i := 0; i < n; i++ {
+			if w := data[got+i]; ts[i] != w.T || vs[i] != w.V {
+				t.Fatalf("point %d=(%v,%v), want (%v,%v)", got+i, ts[i], vs[i], w.T, w.V)
+			}
+		}
+		got += n
+		if n < len(ts) {
+			break
+		}
+	}
+	if got != len(data) || it.Err() != nil {
+		t.Errorf("decoded %d points, err=%v; want %d, nil", got, it.Err(), len(data))
+	}
+	if n := it.NextBatch(ts, vs); n != 0 {
+		t.Errorf("NextBatch() after the end=%d, want 0", n)
+	}
+}
+
+func TestNextBatch64(t *testing.T) {
+	const n = 500
+	s := New64(1e15, Microsecond)
+	for i := int64(0); i < n; i++ {
+		s.Push64(1e15+i*1e6+i%7, float64(i))
+	}
+	s.Finish()
+
+	it, _ := NewIterator64(s.Bytes(), Microsecond)
+	ts := make([]int64, n+1)
+	vs := make([]float64, n+1)
+	if got := it.NextBatch64(ts, vs); got != n {
+		t.Fatalf("NextBatch64()=%d, want %d", got, n)
+	}
+	for i := int64(0); i < n; i++ {
+		if ts[i] != 1e15+i*1e6+i%7 || vs[i] != float64(i) {
+			t.Errorf("point %d=(%v,%v)", i, ts[i], vs[i])
+		}
+	}
+
+	// 32-bit series are reported as by Values64
+	it, _ = NewIterator(indexedBlock())
+	if got := it.NextBatch64(ts, vs); got != len(testdata.TwoHoursData) || ts[0] != int64(testdata.TwoHoursData[0].T) {
+		t.Errorf("NextBatch64(32-bit)=%d, ts[0]=%d", got, ts[0])
+	}
+}
+
+// BenchmarkNextBatch compares decoding a block point by point with Next to
+// decoding it in one batch
+func BenchmarkNextBatch(b *testing.B) {
+	for _, bs := range benchSeries {
+		s := New(bs.points[0].T)
+		for _, p := range bs.points {
+			s.Push(p.T, p.V)
+		}
+		s.Finish()
+		buf := s.Bytes()
+		ts := make([]uint32, len(bs.points))
+		vs := make([]float64, len(bs.points))
+
+		b.Run(bs.name+"/Next", func(b *testing.B) {
+			b.SetBytes(int64(len(bs.points) * 12))
+			for i := 0; i < b.N; i++ {
+				it, _ := NewIterator(buf)
+				for j := 0; it.Next(); j++ {
+					ts[j], vs[j] = it.Values()
+				}
+			}
+		})
+		b.Run(bs.name+"/NextBatch", func(b *testing.B) {
+			b.SetBytes(int64(len(bs.points) * 12))
+			for i := 0; i < b.N; i++ {
+				it, _ := NewIterator(buf)
+				it.NextBatch(ts, vs)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeAll(b *testing.B) {
+	b.SetBytes(int64(len(testdata.TwoHoursData) * 12))
+	buf := indexedBlock()
+	ts := make([]uint32, len(testdata.TwoHoursData))
+	vs := make([]float64, len(testdata.TwoHoursData))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		DecodeAll(buf, ts, vs)
+	}
+}
+
+func TestNextBatchNext(t *testing.T) {
+	vals := withNulls()
+	for _, opts := range append([][]Option{{WithAdaptiveBuckets(10)}}, codecTests...) {
+		// a header lets the series start with a null
+		s := New(0, withOpts(opts, WithHeader())...)
+		pushNulls(t, s, vals, 0, len(vals))
+		// a delta-of-delta too wide for the first three buckets
+		s.Push(1<<20, 1)
+		s.Finish()
+
+		want, _ := NewIterator(s.Bytes())
+		it, _ := NewIterator(s.Bytes())
+		ts := make([]uint32, 5)
+		vs := make([]float64, 5)
+		var got int
+		for {
+			n := it.NextBatch(ts, vs)
+			for i := 0; i < n; i++ {
+				want.Next()
+				wt, wv := want.Values()
+				if ts[i] != wt || math.Float64bits(vs[i]) != math.Float64bits(wv) {
+					t.Fatalf("point %d=(%v,%v), want (%v,%v) from Next", got+i, ts[i], vs[i], wt, wv)
+				}
+			}
+			got += n
+			if n < len(ts) {
+				break
+			}
+		}
+		if got != len(vals)+1 || it.Err() != nil {
+			t.Errorf("decoded %d points, err=%v; want %d, nil", got, it.Err(), len(vals)+1)
+		}
+	}
+}
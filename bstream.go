@@ -6,17 +6,17 @@ import (
 	"io"
 )
 
-// bstream is a stream of bits
+// bstream is a stream of bits.  Bits are collected in a 64-bit accumulator
+// and flushed to the byte stream a whole word at a time.
 type bstream struct {
-	// the data stream
+	// the data stream, holding every flushed word
 	stream []byte
 
-	// how many bits are valid in current byte
-	count uint8
-}
+	// bits not yet flushed to the stream, right-aligned
+	acc uint64
 
-func newBReader(b []byte) *bstream {
-	return &bstream{stream: b, count: 8}
+	// how many bits are valid in acc
+	count uint8
 }
 
 func newBWriter(size int) *bstream {
@@ -26,11 +26,25 @@ func newBWriter(size int) *bstream {
 func (b *bstream) clone() *bstream {
 	d := make([]byte, len(b.stream))
 	copy(d, b.stream)
-	return &bstream{stream: d, count: b.count}
+	return &bstream{stream: d, acc: b.acc, count: b.count}
 }
 
+// bytes returns the stream including any bits still in the accumulator.  The
+// pending bits are appended to a copy of the stream, never to its spare
+// capacity, so the results of several calls can be read at the same time.
 func (b *bstream) bytes() []byte {
-	return b.stream
+	if b.count == 0 {
+		return b.stream
+	}
+
+	var tail [8]byte
+	binary.BigEndian.PutUint64(tail[:], b.acc<<(64-b.count))
+	return append(b.stream[:len(b.stream):len(b.stream)], tail[:(b.count+7)/8]...)
+}
+
+// free returns the number of unused bits in the last byte of the stream
+func (b *bstream) free() uint8 {
+	return (8 - b.count%8) % 8
 }
 
 type bit bool
@@ -41,152 +55,220 @@ const (
 )
 
 func (b *bstream) writeBit(bit bit) {
-
-	if b.count == 0 {
-		b.stream = append(b.stream, 0)
-		b.count = 8
+	var u uint64
+	if bit {
+		u = 1
 	}
 
-	i := len(b.stream) - 1
-
-	if bit {
-		b.stream[i] |= 1 << (b.count - 1)
+	if b.count < 63 {
+		b.acc = b.acc<<1 | u
+		b.count++
+		return
 	}
 
-	b.count--
+	b.flush(u, 1)
 }
 
 func (b *bstream) writeByte(byt byte) {
+	b.writeBits(uint64(byt), 8)
+}
 
-	if b.count == 0 {
-		b.stream = append(b.stream, 0)
-		b.count = 8
+func (b *bstream) writeBits(u uint64, nbits int) {
+	// the shift yields 0 for nbits == 64, keeping every bit
+	u &= 1<<uint(nbits) - 1
+
+	if int(b.count)+nbits < 64 {
+		b.acc = b.acc<<uint(nbits) | u
+		b.count += uint8(nbits)
+		return
 	}
 
-	i := len(b.stream) - 1
+	b.flush(u, nbits)
+}
 
-	// fill up b.b with b.count bits from byt
-	b.stream[i] |= byt >> (8 - b.count)
+// flush fills up the accumulator with the high bits of u, writes it to the
+// stream, and keeps the bits that didn't fit
+func (b *bstream) flush(u uint64, nbits int) {
+	free := 64 - int(b.count)
+	rem := uint(nbits - free)
+	b.acc = b.acc<<uint(free) | u>>rem
+	b.stream = append(b.stream, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(b.stream[len(b.stream)-8:], b.acc)
+	b.acc = u & (1<<rem - 1)
+	b.count = uint8(rem)
+}
 
-	b.stream = append(b.stream, 0)
-	i++
-	b.stream[i] = byt << b.count
+// pad writes zero bits up to the next byte boundary
+func (b *bstream) pad() {
+	b.writeBits(0, int(b.free()))
 }
 
-func (b *bstream) writeBits(u uint64, nbits int) {
-	u <<= (64 - uint(nbits))
-	for nbits >= 8 {
-		byt := byte(u >> 56)
-		b.writeByte(byt)
-		u <<= 8
-		nbits -= 8
+// flushBytes moves every whole byte in the accumulator to the stream, so that
+// bytes has nothing left to add once the stream is padded
+func (b *bstream) flushBytes() {
+	for b.count >= 8 {
+		b.count -= 8
+		b.stream = append(b.stream, byte(b.acc>>b.count))
 	}
+	b.acc &= 1<<b.count - 1
+}
 
-	for nbits > 0 {
-		b.writeBit((u >> 63) == 1)
-		u <<= 1
-		nbits--
+// patch overwrites bytes that have already been written, starting at byte
+// offset off
+func (b *bstream) patch(off int, p []byte) {
+	for i, c := range p {
+		j := off + i
+		if j < len(b.stream) {
+			b.stream[j] = c
+			continue
+		}
+
+		// the byte is still in the accumulator
+		shift := uint(int(b.count) - 8*(j-len(b.stream)+1))
+		b.acc = b.acc&^(0xff<<shift) | uint64(c)<<shift
 	}
 }
 
-func (b *bstream) readBit() (bit, error) {
+// breader reads bits from a byte slice through a cursor.  It never writes to
+// the slice, so any number of readers can share the same bytes.  Bits are
+// loaded into a 64-bit accumulator a word at a time.
+type breader struct {
+	stream []byte
 
-	if len(b.stream) == 0 {
-		return false, io.EOF
-	}
+	// index of the next byte of stream to load
+	i int
 
-	if b.count == 0 {
-		b.stream = b.stream[1:]
-		// did we just run out of stuff to read?
-		if len(b.stream) == 0 {
-			return false, io.EOF
-		}
-		b.count = 8
-	}
+	// buffered bits, left-aligned
+	acc uint64
 
-	b.count--
-	d := b.stream[0] & 0x80
-	b.stream[0] <<= 1
-	return d != 0, nil
+	// how many bits are valid in acc
+	count uint
+
+	// bytes read after stream, for iterators over an open series whose last
+	// bits are still in the writer's accumulator
+	tail []byte
 }
 
-func (b *bstream) readByte() (byte, error) {
+func newBReader(b []byte) *breader {
+	return &breader{stream: b}
+}
 
-	if len(b.stream) == 0 {
-		return 0, io.EOF
+// offset returns the position in bits of the next bit to read
+func (b *breader) offset() int {
+	return b.i*8 - int(b.count)
+}
+
+// remaining returns the number of bits left to read
+func (b *breader) remaining() int {
+	return (len(b.stream)+len(b.tail))*8 - b.offset()
+}
+
+// seek moves the cursor to the given offset in bits
+func (b *breader) seek(off int) {
+	b.i, b.acc, b.count = off/8, 0, 0
+	if r := off % 8; r != 0 {
+		b.readBits(r)
 	}
+}
 
-	if b.count == 0 {
-		b.stream = b.stream[1:]
+// refill loads as many whole bytes into the accumulator as will fit
+func (b *breader) refill() {
 
-		if len(b.stream) == 0 {
-			return 0, io.EOF
-		}
+	// clear anything left below the valid bits
+	b.acc &^= ^uint64(0) >> b.count
 
-		b.count = 8
+	if len(b.stream)-b.i >= 8 {
+		w := binary.BigEndian.Uint64(b.stream[b.i:])
+		n := (64 - b.count) / 8
+		b.acc |= w >> b.count
+		b.i += int(n)
+		b.count += 8 * n
+		return
 	}
 
-	if b.count == 8 {
-		b.count = 0
-		return b.stream[0], nil
+	for b.count <= 56 && b.i < len(b.stream) {
+		b.acc |= uint64(b.stream[b.i]) << (56 - b.count)
+		b.i++
+		b.count += 8
 	}
 
-	byt := b.stream[0]
-	b.stream = b.stream[1:]
-
-	if len(b.stream) == 0 {
-		return 0, io.EOF
+	for b.count <= 56 && b.i-len(b.stream) < len(b.tail) {
+		b.acc |= uint64(b.tail[b.i-len(b.stream)]) << (56 - b.count)
+		b.i++
+		b.count += 8
 	}
+}
 
-	byt |= b.stream[0] >> b.count
-	b.stream[0] <<= (8 - b.count)
+func (b *breader) readBit() (bit, error) {
 
-	return byt, nil
+	if b.count == 0 {
+		return b.readBitRefill()
+	}
+
+	d := b.acc >> 63
+	b.acc <<= 1
+	b.count--
+	return d != 0, nil
 }
 
-func (b *bstream) readBits(nbits int) (uint64, error) {
+func (b *breader) readBitRefill() (bit, error) {
 
-	var u uint64
+	b.refill()
+	// did we just run out of stuff to read?
+	if b.count == 0 {
+		return false, io.EOF
+	}
 
-	for nbits >= 8 {
-		byt, err := b.readByte()
-		if err != nil {
-			return 0, err
-		}
+	return b.readBit()
+}
 
-		u = (u << 8) | uint64(byt)
-		nbits -= 8
-	}
+func (b *breader) readByte() (byte, error) {
+	u, err := b.readBits(8)
+	return byte(u), err
+}
 
-	if nbits == 0 {
-		return u, nil
+func (b *breader) readBits(nbits int) (uint64, error) {
+
+	n := uint(nbits)
+	if n > b.count {
+		return b.readBitsRefill(nbits)
 	}
 
-	if nbits > int(b.count) {
-		u = (u << uint(b.count)) | uint64(b.stream[0]>>(8-b.count))
-		nbits -= int(b.count)
-		b.stream = b.stream[1:]
+	u := b.acc >> (64 - n)
+	b.acc <<= n
+	b.count -= n
+	return u, nil
+}
 
-		if len(b.stream) == 0 {
-			return 0, io.EOF
-		}
-		b.count = 8
+func (b *breader) readBitsRefill(nbits int) (uint64, error) {
+
+	if nbits > b.remaining() {
+		return 0, io.EOF
 	}
 
-	u = (u << uint(nbits)) | uint64(b.stream[0]>>(8-uint(nbits)))
-	b.stream[0] <<= uint(nbits)
-	b.count -= uint8(nbits)
+	// a refill is only guaranteed to leave 57 bits in the accumulator
+	if nbits > 56 {
+		hi, _ := b.readBits(nbits - 32)
+		lo, _ := b.readBits(32)
+		return hi<<32 | lo, nil
+	}
+
+	b.refill()
+
+	u := b.acc >> (64 - uint(nbits))
+	b.acc <<= uint(nbits)
+	b.count -= uint(nbits)
 	return u, nil
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface
 func (b *bstream) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, b.count)
+	err := binary.Write(buf, binary.BigEndian, b.free())
 	if err != nil {
 		return nil, err
 	}
-	err = binary.Write(buf, binary.BigEndian, b.stream)
+	err = binary.Write(buf, binary.BigEndian, b.bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -196,10 +278,28 @@ func (b *bstream) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
 func (b *bstream) UnmarshalBinary(bIn []byte) error {
 	buf := bytes.NewReader(bIn)
-	err := binary.Read(buf, binary.BigEndian, &b.count)
+	var free uint8
+	err := binary.Read(buf, binary.BigEndian, &free)
 	if err != nil {
 		return err
 	}
+	// earlier releases wrote 8 after a whole byte, leaving an empty one
+	if free > 8 {
+		return ErrCorrupt
+	}
 	b.stream = make([]byte, buf.Len())
-	return binary.Read(buf, binary.BigEndian, &b.stream)
+	err = binary.Read(buf, binary.BigEndian, &b.stream)
+	if err != nil {
+		return err
+	}
+
+	// move any partial byte back into the accumulator
+	b.acc, b.count = 0, 0
+	if free != 0 && len(b.stream) != 0 {
+		last := b.stream[len(b.stream)-1]
+		b.stream = b.stream[:len(b.stream)-1]
+		b.acc = uint64(last >> free)
+		b.count = 8 - free
+	}
+	return nil
 }
@@ -1,7 +1,9 @@
 package tsz
 
 import (
+	"bytes"
 	"io"
+	"math/rand"
 	"testing"
 )
 
@@ -13,7 +15,7 @@ func TestNewBWriter(t *testing.T) {
 }
 
 func TestReadBitEOF1(t *testing.T) {
-	b := newBWriter(1)
+	b := newBReader(nil)
 	_, err := b.readBit()
 	if err != io.EOF {
 		t.Errorf("Unexpected value: %v\n", err)
@@ -22,7 +24,7 @@ func TestReadBitEOF1(t *testing.T) {
 
 func TestReadBitEOF2(t *testing.T) {
 	b := newBReader([]byte{1})
-	b.count = 0
+	b.readBits(8)
 	_, err := b.readBit()
 	if err != io.EOF {
 		t.Errorf("Unexpected value: %v\n", err)
@@ -30,7 +32,7 @@ func TestReadBitEOF2(t *testing.T) {
 }
 
 func TestReadByteEOF1(t *testing.T) {
-	b := newBWriter(1)
+	b := newBReader(nil)
 	_, err := b.readByte()
 	if err != io.EOF {
 		t.Errorf("Unexpected value: %v\n", err)
@@ -39,7 +41,7 @@ func TestReadByteEOF1(t *testing.T) {
 
 func TestReadByteEOF2(t *testing.T) {
 	b := newBReader([]byte{1})
-	b.count = 0
+	b.readBits(8)
 	_, err := b.readByte()
 	if err != io.EOF {
 		t.Errorf("Unexpected value: %v\n", err)
@@ -48,7 +50,7 @@ func TestReadByteEOF2(t *testing.T) {
 
 func TestReadByteEOF3(t *testing.T) {
 	b := newBReader([]byte{1})
-	b.count = 16
+	b.readBits(4)
 	_, err := b.readByte()
 	if err != io.EOF {
 		t.Errorf("Unexpected value: %v\n", err)
@@ -63,10 +65,183 @@ func TestReadBitsEOF(t *testing.T) {
 	}
 }
 
+func TestReadBits(t *testing.T) {
+	w := newBWriter(8)
+	w.writeBits(0x05, 3)
+	w.writeBit(one)
+	w.writeBits(0x1234, 13)
+	w.writeBits(0xdeadbeefcafe, 48)
+	w.writeByte(0xa5)
+
+	in := w.bytes()
+	orig := append([]byte(nil), in...)
+
+	b := newBReader(in)
+	if u, err := b.readBits(3); err != nil || u != 0x05 {
+		t.Errorf("readBits(3)=(%x,%v), want (5,nil)", u, err)
+	}
+	if bit, err := b.readBit(); err != nil || bit != one {
+		t.Errorf("readBit()=(%v,%v), want (one,nil)", bit, err)
+	}
+	if u, err := b.readBits(13); err != nil || u != 0x1234 {
+		t.Errorf("readBits(13)=(%x,%v), want (1234,nil)", u, err)
+	}
+	if u, err := b.readBits(48); err != nil || u != 0xdeadbeefcafe {
+		t.Errorf("readBits(48)=(%x,%v), want (deadbeefcafe,nil)", u, err)
+	}
+	if byt, err := b.readByte(); err != nil || byt != 0xa5 {
+		t.Errorf("readByte()=(%x,%v), want (a5,nil)", byt, err)
+	}
+
+	for i := range in {
+		if in[i] != orig[i] {
+			t.Fatalf("reader modified its input: %x, want %x", in, orig)
+		}
+	}
+}
+
 func TestUnmarshalBinaryErr(t *testing.T) {
 	b := &bstream{}
 	err := b.UnmarshalBinary([]byte{})
 	if err == nil {
 		t.Errorf("An error was expected\n")
 	}
+
+	// more free bits than a byte has
+	if err := b.UnmarshalBinary([]byte{9, 0xff}); err != ErrCorrupt {
+		t.Errorf("UnmarshalBinary(free=9)=%v, want %v", err, ErrCorrupt)
+	}
+}
+
+// refWriter writes one bit at a time, as the original bstream did
+type refWriter struct {
+	stream []byte
+	count  uint8
+}
+
+func (r *refWriter) writeBits(u uint64, nbits int) {
+	for nbits > 0 {
+		nbits--
+		if r.count == 0 {
+			r.stream = append(r.stream, 0)
+			r.count = 8
+		}
+		if (u>>uint(nbits))&1 == 1 {
+			r.stream[len(r.stream)-1] |= 1 << (r.count - 1)
+		}
+		r.count--
+	}
+}
+
+func TestWriteBitsSameBytes(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+
+	w := newBWriter(0)
+	var ref refWriter
+	var vals []uint64
+	var widths []int
+	for i := 0; i < 2000; i++ {
+		u, nbits := rnd.Uint64(), 1+rnd.Intn(64)
+		w.writeBits(u, nbits)
+		ref.writeBits(u, nbits)
+		if nbits < 64 {
+			u &= 1<<uint(nbits) - 1
+		}
+		vals, widths = append(vals, u), append(widths, nbits)
+
+		if !bytes.Equal(w.bytes(), ref.stream) {
+			t.Fatalf("bytes differ after %d writes", i+1)
+		}
+		if w.free() != ref.count {
+			t.Fatalf("free()=%d, want %d", w.free(), ref.count)
+		}
+	}
+
+	b := newBReader(w.bytes())
+	for i := range vals {
+		u, err := b.readBits(widths[i])
+		if err != nil || u != vals[i] {
+			t.Fatalf("readBits(%d)=(%x,%v), want (%x,nil)", widths[i], u, err, vals[i])
+		}
+	}
+	if b.remaining() >= 8 {
+		t.Errorf("remaining()=%d, want < 8", b.remaining())
+	}
+}
+
+func TestMarshalBinaryPartialByte(t *testing.T) {
+	w := newBWriter(0)
+	w.writeBits(0xdeadbeefcafe, 48)
+	w.writeBits(0x1ff, 9)
+
+	m, err := w.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var w2 bstream
+	if err := w2.UnmarshalBinary(m); err != nil {
+		t.Fatal(err)
+	}
+	w.writeBits(0x0f, 4)
+	w2.writeBits(0x0f, 4)
+	if !bytes.Equal(w.bytes(), w2.bytes()) {
+		t.Errorf("bytes()=%x, want %x", w2.bytes(), w.bytes())
+	}
+}
+
+func TestUnmarshalBinaryEmptyByte(t *testing.T) {
+	// as earlier releases wrote a stream ending on a whole byte
+	var w bstream
+	if err := w.UnmarshalBinary([]byte{8, 0xab, 0}); err != nil {
+		t.Fatal(err)
+	}
+	w.writeBits(0x0f, 4)
+	if want := []byte{0xab, 0xf0}; !bytes.Equal(w.bytes(), want) {
+		t.Errorf("bytes()=%x, want %x", w.bytes(), want)
+	}
+}
+
+// a mix of field widths similar to what the encoder writes
+var benchWidths = []int{1, 2, 7, 1, 1, 5, 6, 23, 1, 3, 9, 1, 2, 12, 64, 1, 14, 32}
+
+func benchBits() int {
+	var n int
+	for _, w := range benchWidths {
+		n += w
+	}
+	return n
+}
+
+func BenchmarkWriteBits(b *testing.B) {
+	b.SetBytes(int64(16 * benchBits() / 8))
+	for i := 0; i < b.N; i++ {
+		w := newBWriter(512)
+		for j := 0; j < 16; j++ {
+			for _, n := range benchWidths {
+				w.writeBits(0x5555555555555555, n)
+			}
+		}
+	}
+}
+
+func BenchmarkReadBits(b *testing.B) {
+	w := newBWriter(512)
+	for j := 0; j < 16; j++ {
+		for _, n := range benchWidths {
+			w.writeBits(0x5555555555555555, n)
+		}
+	}
+	buf := w.bytes()
+
+	b.SetBytes(int64(16 * benchBits() / 8))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := newBReader(buf)
+		for j := 0; j < 16; j++ {
+			for _, n := range benchWidths {
+				r.readBits(n)
+			}
+		}
+	}
 }
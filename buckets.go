@@ -0,0 +1,146 @@
+package tsz
+
+import "math/bits"
+
+// The widths of the first three delta-of-delta buckets of the timestamps,
+// selected by the '10', '110' and '1110' control codes, may be set for a
+// series instead of taken from its unit.  The last bucket always holds a full
+// 32 or 64-bit delta-of-delta.  The header records how they are chosen, after
+// the tolerance:
+//
+//	buckets    24 bits  a table: three 8-bit widths
+//	learn      32 bits  adaptive: the number of points they are learned from
+//
+// The first points of an adaptive series use the buckets of its unit.  The
+// widths that would have stored their delta-of-deltas in the fewest bits are
+// written right after them, and used for the rest of the series:
+//
+//	buckets    18 bits  three 6-bit widths
+
+// the ways the buckets are chosen, as recorded in the header
+const (
+	bucketsUnit     = iota // the buckets of the unit
+	bucketsTable           // a table in the header
+	bucketsAdaptive        // learned from the first points
+)
+
+// WithBuckets sets the widths of the first three delta-of-delta buckets of
+// the timestamps, which must be increasing and narrower than a timestamp.
+// Narrow buckets suit regular series with a little jitter, and wide ones
+// irregular series.  It implies WithHeader, and replaces WithAdaptiveBuckets.
+func WithBuckets(w1, w2, w3 int) Option {
+	if !(0 < w1 && w1 < w2 && w2 < w3) {
+		panic("tsz: invalid buckets")
+	}
+	return func(f *format) {
+		if w3 >= f.ts().dod[3] {
+			panic("tsz: invalid buckets")
+		}
+		f.framed = true
+		f.buckets = [3]uint8{uint8(w1), uint8(w2), uint8(w3)}
+		f.learn = 0
+	}
+}
+
+// WithAdaptiveBuckets chooses the widths of the first three delta-of-delta
+// buckets of the timestamps by the delta-of-deltas of the first n points,
+// which are stored in the buckets of the unit.  It implies WithHeader, and
+// replaces WithBuckets.
+func WithAdaptiveBuckets(n int) Option {
+	if n <= 0 || uint64(n) >= 1<<32 {
+		panic("tsz: invalid number of points to learn buckets from")
+	}
+	return func(f *format) {
+		f.framed = true
+		f.buckets = [3]uint8{}
+		f.learn = n
+	}
+}
+
+// validBuckets reports whether the widths of the first three buckets fit a
+// series with a last bucket of the given width
+func validBuckets(b [3]uint8, last int) bool {
+	return 0 < b[0] && b[0] < b[1] && b[1] < b[2] && int(b[2]) < last
+}
+
+// dodWidth returns the width of the narrowest bucket a non-zero
+// delta-of-delta fits in
+func dodWidth(dod int64) int {
+	w := bits.Len64(uint64(-dod)) + 1
+	if dod > 0 {
+		w = bits.Len64(uint64(dod-1)) + 1
+	}
+	if w > 64 {
+		w = 64
+	}
+	return w
+}
+
+// learnBuckets returns the widths of the first three buckets that store the
+// delta-of-deltas counted by width in the fewest bits, keeping the given
+// widths unless others are better
+func learnBuckets(widths []int, b [3]uint8, last int) [3]uint8 {
+	// fit[w] is the number of delta-of-deltas at most w bits wide
+	var fit [65]int
+	for w := 1; w <= 64; w++ {
+		fit[w] = fit[w-1] + widths[w]
+	}
+
+	cost := func(w1, w2, w3 int) int {
+		return fit[w1]*(2+w1) + (fit[w2]-fit[w1])*(3+w2) + (fit[w3]-fit[w2])*(4+w3) + (fit[64]-fit[w3])*(4+last)
+	}
+
+	best := cost(int(b[0]), int(b[1]), int(b[2]))
+	for w1 := 1; w1 < last; w1++ {
+		for w2 := w1 + 1; w2 < last; w2++ {
+			for w3 := w2 + 1; w3 < last; w3++ {
+				if c := cost(w1, w2, w3); c < best {
+					best = c
+					b = [3]uint8{uint8(w1), uint8(w2), uint8(w3)}
+				}
+			}
+		}
+	}
+	return b
+}
+
+// countDod counts the delta-of-delta of a point the buckets are learned from
+func (s *Series) countDod(dod int64) {
+	if s.dods != nil && dod != 0 {
+		s.dods[dodWidth(dod)]++
+	}
+}
+
+// writeBuckets writes the buckets learned from the first points, and uses
+// them from then on
+func (s *Series) writeBuckets() {
+	var b [3]uint8
+	for i := range b {
+		b[i] = uint8(s.ts.dod[i])
+	}
+	b = learnBuckets(s.dods, b, s.ts.dod[3])
+	for _, w := range b {
+		s.bw.writeBits(uint64(w), 6)
+	}
+	s.fmt.buckets = b
+	s.ts = s.fmt.ts()
+	s.dods = nil
+}
+
+// readBuckets reads the buckets learned from the first points
+func (it *Iter) readBuckets() error {
+	var b [3]uint8
+	for i := range b {
+		w, err := it.br.readBits(6)
+		if err != nil {
+			return err
+		}
+		b[i] = uint8(w)
+	}
+	if !validBuckets(b, it.ts.dod[3]) {
+		return ErrCorrupt
+	}
+	it.fmt.buckets = b
+	it.ts = it.fmt.ts()
+	return nil
+}
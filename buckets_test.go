@@ -0,0 +1,226 @@
+package tsz
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// jittered is a 10-second scrape in milliseconds, with a little jitter
+func jittered() []Point {
+	r := rand.New(rand.NewSource(6))
+	var points []Point
+	for i := 0; i < 2000; i++ {
+		points = append(points, Point{T: 1e12 + int64(i)*10000 + r.Int63n(20), V: float64(i % 7)})
+	}
+	return points
+}
+
+// events is an irregular series in milliseconds
+func events() []Point {
+	r := rand.New(rand.NewSource(7))
+	var points []Point
+	t := int64(1e12)
+	for i := 0; i < 2000; i++ {
+		t += int64(r.ExpFloat64() * 30000)
+		points = append(points, Point{T: t, V: float64(i)})
+	}
+	return points
+}
+
+func TestBucketsRoundtrip(t *testing.T) {
+	for _, data := range [][]Point{jittered(), events()} {
+		for _, opts := range [][]Option{
+			{WithBuckets(3, 8, 20)},
+			{WithAdaptiveBuckets(1)},
+			{WithAdaptiveBuckets(64), WithIndex(10), WithChecksum()},
+			{WithAdaptiveBuckets(5000)},
+		} {
+			s := New64(data[0].T-100, Millisecond, opts...)
+			for _, p := range data {
+				if err := s.Push64(p.T, p.V); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Finish()
+
+			if err := Verify(s.Bytes()); err != nil {
+				t.Fatalf("Verify()=%v", err)
+			}
+			it, err := NewIterator64(s.Bytes(), Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var n int
+			for it.Next() {
+				if ts, v := it.Values64(); ts != data[n].T || v != data[n].V {
+					t.Errorf("point %d=(%d, %v), want (%d, %v)", n, ts, v, data[n].T, data[n].V)
+				}
+				n++
+			}
+			if n != len(data) || it.Err() != nil {
+				t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(data))
+			}
+		}
+	}
+}
+
+func TestBucketsInt(t *testing.T) {
+	data := events()
+	s := NewInt64(data[0].T, Millisecond, WithAdaptiveBuckets(10))
+	for _, p := range data {
+		s.Push(p.T, int64(p.V))
+	}
+	s.Finish()
+
+	it, err := NewIntIterator64(s.Bytes(), Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for it.Next() {
+		if ts, v := it.Values(); ts != data[n].T || v != int64(data[n].V) {
+			t.Errorf("point %d=(%d, %d), want (%d, %v)", n, ts, v, data[n].T, data[n].V)
+		}
+		n++
+	}
+	if n != len(data) || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(data))
+	}
+}
+
+func TestAdaptiveBucketsSmaller(t *testing.T) {
+	for name, data := range map[string][]Point{"jittered": jittered(), "events": events()} {
+		size := func(opts ...Option) int {
+			s := New64(data[0].T, Millisecond, opts...)
+			for _, p := range data {
+				s.Push64(p.T, p.V)
+			}
+			s.Finish()
+			return len(s.Bytes())
+		}
+
+		if unit, learned := size(WithHeader()), size(WithAdaptiveBuckets(100)); learned >= unit {
+			t.Errorf("%s: took %d bytes with adaptive buckets, %d with those of the unit", name, learned, unit)
+		}
+	}
+}
+
+func TestAdaptiveBucketsOpen(t *testing.T) {
+	data := events()
+	s := New64(data[0].T, Millisecond, WithAdaptiveBuckets(8))
+	for i, p := range data[:20] {
+		s.Push64(p.T, p.V)
+
+		// before, at and after the buckets are learned
+		it := s.Iter()
+		var n int
+		for it.Next() {
+			if ts, _ := it.Values64(); ts != data[n].T {
+				t.Errorf("%d points: point %d at %d, want %d", i+1, n, ts, data[n].T)
+			}
+			n++
+		}
+		if n != i+1 || it.Err() != nil {
+			t.Errorf("%d points: read %d, err=%v", i+1, n, it.Err())
+		}
+	}
+}
+
+func TestAdaptiveBucketsSeek(t *testing.T) {
+	data := events()
+	s := New64(data[0].T, Millisecond, WithAdaptiveBuckets(50), WithIndex(8))
+	for _, p := range data {
+		s.Push64(p.T, p.V)
+	}
+	s.Finish()
+
+	for _, i := range []int{3, 49, 50, 51, 400, len(data) - 1} {
+		it, _ := NewIterator64(s.Bytes(), Millisecond)
+		if !it.Seek64(data[i].T) {
+			t.Fatalf("Seek64(point %d)=false, want true, err=%v", i, it.Err())
+		}
+		if _, v := it.Values64(); v != data[i].V {
+			t.Errorf("Seek64(point %d) found %v, want %v", i, v, data[i].V)
+		}
+	}
+}
+
+func TestAdaptiveBucketsMarshalBinary(t *testing.T) {
+	data := events()
+	opt := WithAdaptiveBuckets(100)
+	for _, cut := range []int{10, 100, 500} {
+		whole := New64(data[0].T, Millisecond, opt)
+		part := New64(data[0].T, Millisecond, opt)
+		for i, p := range data {
+			whole.Push64(p.T, p.V)
+			if i < cut {
+				part.Push64(p.T, p.V)
+			}
+		}
+		whole.Finish()
+
+		b, err := part.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := New64(data[0].T, Millisecond, opt)
+		if err := s.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range data[cut:] {
+			s.Push64(p.T, p.V)
+		}
+		s.Finish()
+
+		if !bytes.Equal(s.Bytes(), whole.Bytes()) {
+			t.Errorf("series unmarshaled after %d points differs from one never marshaled", cut)
+		}
+	}
+}
+
+func TestLearnBuckets(t *testing.T) {
+	widths := make([]int, 65)
+	widths[3] = 100
+	widths[10] = 10
+	widths[40] = 1
+	if b := learnBuckets(widths, [3]uint8{7, 9, 12}, 64); b != [3]uint8{3, 10, 40} {
+		t.Errorf("learnBuckets()=%v, want [3 10 40]", b)
+	}
+
+	// nothing to learn from
+	if b := learnBuckets(make([]int, 65), [3]uint8{7, 9, 12}, 64); b != [3]uint8{7, 9, 12} {
+		t.Errorf("learnBuckets(no delta-of-deltas)=%v, want [7 9 12]", b)
+	}
+}
+
+func TestDodWidth(t *testing.T) {
+	for _, tt := range []struct {
+		dod   int64
+		width int
+	}{{1, 1}, {-1, 2}, {2, 2}, {64, 7}, {65, 8}, {-63, 7}, {-64, 8}, {-1 << 63, 64}, {1<<63 - 1, 64}} {
+		w := dodWidth(tt.dod)
+		// the 64-bit bucket holds anything, but its range overflows
+		if w != tt.width || (w < 64 && !fitsBucket(tt.dod, w)) || (w > 1 && fitsBucket(tt.dod, w-1)) {
+			t.Errorf("dodWidth(%d)=%d, want %d", tt.dod, w, tt.width)
+		}
+	}
+}
+
+func TestBucketsPanics(t *testing.T) {
+	for _, f := range []func(){
+		func() { WithBuckets(0, 1, 2) },
+		func() { WithBuckets(3, 3, 4) },
+		func() { New(0, WithBuckets(7, 9, 32)) },
+		func() { WithAdaptiveBuckets(0) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,294 @@
+package tsz
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The Chimp codec (Liakos et al., VLDB 2022) also stores the XOR of each value
+// with the previous one, under a two-bit flag:
+//
+//	'00'                                     same value
+//	'01' + leading 3 bits + length 6 bits    XOR with more than 6 trailing zeros,
+//	     + meaningful bits                   stored without them
+//	'10' + 64-leading bits                   XOR with the same leading zeros as
+//	                                         the previous one
+//	'11' + leading 3 bits + 64-leading bits  XOR with new leading zeros
+//
+// The number of leading zeros is rounded down to one of eight buckets, so it
+// takes 3 bits.  Only the '11' case sets the leading zeros that '10' reuses.
+//
+// Chimp128 keeps the last 128 values in a ring, and may XOR a value with any
+// of them instead of the previous one.  The '00' and '01' cases are followed
+// by the 7-bit ring position of that value, and '01' is taken from 14
+// trailing zeros:
+//
+//	'00' + position 7 bits                  a recent value
+//	'01' + position 7 bits + leading 3 bits XOR with a recent value
+//	     + length 6 bits + meaningful bits
+//
+// The encoder looks up the last value equal to the new one and the last value
+// ending in the same 14 bits in two tables, indexed by a hash of each value
+// and by its low bits, and uses whichever of them and the previous value
+// takes the fewest bits.  The decoder only needs the ring.
+
+// chimpLeading are the leading zero buckets
+var chimpLeading = [8]uint8{0, 8, 12, 16, 18, 20, 22, 24}
+
+// chimpRound maps a number of leading zeros to its bucket
+var chimpRound [65]uint8
+
+func init() {
+	for i := range chimpRound {
+		for code, l := range chimpLeading {
+			if uint8(i) >= l {
+				chimpRound[i] = uint8(code)
+			}
+		}
+	}
+}
+
+// the fewest trailing zeros stored by the '01' case of Chimp and Chimp128
+const (
+	chimpTrailing    = 6
+	chimp128Trailing = 6 + chimpRingBits
+)
+
+const (
+	chimpRingBits = 7
+	chimpRingSize = 1 << chimpRingBits
+	chimpKeyBits  = chimp128Trailing + 1
+)
+
+// chimpRing holds the last values of a Chimp128 series, value i at
+// i%chimpRingSize
+type chimpRing struct {
+	vals [chimpRingSize]uint64
+
+	// only kept by the encoder
+	lookup *chimpLookup
+}
+
+// chimpLookup holds the ring position of the latest value with each hash and
+// with each pattern of low bits
+type chimpLookup struct {
+	equal [1 << chimpKeyBits]uint8
+	low   [1 << chimpKeyBits]uint8
+}
+
+func chimpHash(u uint64) uint64 {
+	return (u * 0x9e3779b97f4a7c15) >> (64 - chimpKeyBits)
+}
+
+func chimpLow(u uint64) uint64 {
+	return u & (1<<chimpKeyBits - 1)
+}
+
+// add stores value i in the ring
+func (r *chimpRing) add(i int, u uint64) {
+	j := i % chimpRingSize
+	r.vals[j] = u
+	if r.lookup != nil {
+		r.lookup.equal[chimpHash(u)] = uint8(j)
+		r.lookup.low[chimpLow(u)] = uint8(j)
+	}
+}
+
+// match returns the ring position of a recent value equal to u or, failing
+// that, with the same low bits, if there is one.  Its XOR with u is zero or
+// has more than chimp128Trailing trailing zeros.
+func (r *chimpRing) match(u uint64) (int, bool) {
+	// the positions may have been taken over by later values
+	if j := r.lookup.equal[chimpHash(u)]; r.vals[j] == u {
+		return int(j), true
+	}
+	j := r.lookup.low[chimpLow(u)]
+	return int(j), chimpLow(r.vals[j]) == chimpLow(u)
+}
+
+// chimp128Bits returns how many bits Chimp128 takes to store a value by its
+// XOR with another
+func (s *Series) chimp128Bits(xor uint64) int {
+	if xor == 0 {
+		return 2 + chimpRingBits
+	}
+
+	leading := int(chimpLeading[chimpRound[bits.LeadingZeros64(xor)]])
+	trailing := bits.TrailingZeros64(xor)
+
+	switch {
+	case trailing > chimp128Trailing:
+		return 2 + chimpRingBits + 3 + 6 + 64 - leading - trailing
+	case leading == int(s.leading):
+		return 2 + 64 - leading
+	default:
+		return 2 + 3 + 64 - leading
+	}
+}
+
+// WithChimp encodes values with the Chimp codec instead of Gorilla XOR.  It
+// implies WithHeader.  Like the other value codecs, it replaces any codec
+// chosen by an earlier option, and is ignored by integer series.
+func WithChimp() Option {
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.codec = codecChimp
+	}
+}
+
+// WithChimp128 encodes values with the Chimp128 codec, which compares each
+// value with the last 128 instead of only the previous one.  It suits series
+// that keep returning to a few values, but takes 9 bits for a value equal to
+// the previous one, where the other codecs take one or two.  It implies
+// WithHeader, and replaces or is ignored like WithChimp.
+//
+// The skip index can't hold the ring of recent values the decoder needs, so
+// these series have no index and WithIndex is ignored.
+func WithChimp128() Option {
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.codec = codecChimp128
+	}
+}
+
+func (s *Series) pushChimp(v float64) {
+	u := math.Float64bits(v)
+	xor := u ^ math.Float64bits(s.val)
+	threshold := uint8(chimpTrailing)
+
+	// Chimp128 may use a recent value instead of the previous one, the point
+	// before this one
+	ref := (s.n - 2) % chimpRingSize
+	if s.ring != nil {
+		threshold = chimp128Trailing
+		if j, ok := s.ring.match(u); ok {
+			if x := u ^ s.ring.vals[j]; s.chimp128Bits(x) < s.chimp128Bits(xor) {
+				ref, xor = j, x
+			}
+		}
+	}
+
+	if xor == 0 {
+		s.bw.writeBits(0x00, 2) // '00'
+		s.writeChimpRef(ref)
+		s.leading = ^uint8(0)
+		return
+	}
+
+	code := chimpRound[bits.LeadingZeros64(xor)]
+	leading := chimpLeading[code]
+	trailing := uint8(bits.TrailingZeros64(xor))
+
+	switch {
+	case trailing > threshold:
+		sigbits := 64 - leading - trailing
+		s.bw.writeBits(0x01, 2) // '01'
+		s.writeChimpRef(ref)
+		s.bw.writeBits(uint64(code), 3)
+		s.bw.writeBits(uint64(sigbits), 6)
+		s.bw.writeBits(xor>>trailing, int(sigbits))
+		s.leading = ^uint8(0)
+	case leading == s.leading:
+		s.bw.writeBits(0x02, 2) // '10'
+		s.bw.writeBits(xor, 64-int(leading))
+	default:
+		s.bw.writeBits(0x03, 2) // '11'
+		s.bw.writeBits(uint64(code), 3)
+		s.bw.writeBits(xor, 64-int(leading))
+		s.leading = leading
+	}
+}
+
+// writeChimpRef writes the ring position of the value a Chimp128 XOR is
+// taken with
+func (s *Series) writeChimpRef(ref int) {
+	if s.ring != nil {
+		s.bw.writeBits(uint64(ref), chimpRingBits)
+	}
+}
+
+// readChimpRef returns the value a Chimp128 XOR is taken with, or the
+// previous value for Chimp
+func (it *Iter) readChimpRef() (uint64, error) {
+	if it.ring == nil {
+		return math.Float64bits(it.val), nil
+	}
+	ref, err := it.br.readBits(chimpRingBits)
+	if err != nil {
+		return 0, err
+	}
+	return it.ring.vals[ref], nil
+}
+
+func (it *Iter) readChimp() error {
+	flag, err := it.br.readBits(2)
+	if err != nil {
+		return err
+	}
+
+	prev := math.Float64bits(it.val)
+	var xor uint64
+	switch flag {
+	case 0x00:
+		prev, err = it.readChimpRef()
+		if err != nil {
+			return err
+		}
+		it.leading = ^uint8(0)
+	case 0x01:
+		prev, err = it.readChimpRef()
+		if err != nil {
+			return err
+		}
+		code, err := it.br.readBits(3)
+		if err != nil {
+			return err
+		}
+		sigbits, err := it.br.readBits(6)
+		if err != nil {
+			return err
+		}
+		if code == 0 && sigbits == 0 {
+			it.null = true
+			return nil
+		}
+		leading := chimpLeading[code]
+		if sigbits == 0 || int(leading)+int(sigbits) > 64 {
+			return ErrCorrupt
+		}
+		xor, err = it.br.readBits(int(sigbits))
+		if err != nil {
+			return err
+		}
+		xor <<= 64 - uint(leading) - uint(sigbits)
+		it.leading = ^uint8(0)
+	case 0x02:
+		if it.leading == ^uint8(0) {
+			// there are no leading zeros to reuse
+			return ErrCorrupt
+		}
+		xor, err = it.br.readBits(64 - int(it.leading))
+		if err != nil {
+			return err
+		}
+	case 0x03:
+		code, err := it.br.readBits(3)
+		if err != nil {
+			return err
+		}
+		it.leading = chimpLeading[code]
+		xor, err = it.br.readBits(64 - int(it.leading))
+		if err != nil {
+			return err
+		}
+	}
+
+	it.val = math.Float64frombits(prev ^ xor)
+	return nil
+}
@@ -0,0 +1,33 @@
+package tsz
+
+import "testing"
+
+func TestChimp128NoIndex(t *testing.T) {
+	for _, opts := range [][]Option{{WithChimp128(), WithIndex(2)}, {WithIndex(2), WithChimp128()}} {
+		s := New(0, opts...)
+		for i := 0; i < 10; i++ {
+			s.Push(uint32(i), float64(i))
+		}
+		s.Finish()
+
+		it, err := NewIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.fmt.indexed || s.index != nil {
+			t.Errorf("Chimp128 series has a skip index")
+		}
+	}
+}
+
+func TestChimpRound(t *testing.T) {
+	for i := 0; i <= 64; i++ {
+		l := chimpLeading[chimpRound[i]]
+		if int(l) > i {
+			t.Errorf("chimpRound[%d] is %d leading zeros", i, l)
+		}
+		if int(chimpRound[i]) < len(chimpLeading)-1 && int(chimpLeading[chimpRound[i]+1]) <= i {
+			t.Errorf("chimpRound[%d]=%d, a smaller bucket than needed", i, chimpRound[i])
+		}
+	}
+}
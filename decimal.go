@@ -0,0 +1,223 @@
+package tsz
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Decimal series store a value with a few fractional digits as the integer it
+// becomes when scaled by a power of ten:
+//
+//	'0'                               same value
+//	'10'  + bucket                    scaled integer delta
+//	'110' + digits 4 bits + bucket    scaled integer delta at a new number of
+//	                                  fractional digits
+//	'11'  + XOR value                 exception, as the XOR codec stores a
+//	                                  value that changed, starting with '1'
+//
+// Deltas are zigzag encoded in the buckets of an IntSeries, except that the
+// last one holds a 6-bit length followed by that many bits.
+//
+// The number of fractional digits starts as the fewest the first value needs,
+// and changes whenever another number of digits stores a value in fewer bits.
+// A value is only scaled if dividing the integer by the power of ten gives
+// back the same float64, bit for bit; every other value, including -0, NaNs,
+// infinities and decimals too long to scale, is an exception.  Deltas are
+// taken from the previous value scaled to the current digits, whatever way it
+// was stored.
+
+// maxDigits is the most fractional digits a value is scaled by
+const maxDigits = 15
+
+var pow10 = [maxDigits + 1]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15}
+
+// WithDecimal encodes values as decimals with a few fractional digits, such
+// as prices, percentages or temperatures, which have noisy mantissas that
+// XOR compresses poorly.  Every float64 is still decoded bit for bit.  It
+// implies WithHeader, and replaces or is ignored like the other value codecs.
+func WithDecimal() Option {
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.codec = codecDecimal
+	}
+}
+
+// decimalScale returns v scaled by 10^digits, if that is an integer that
+// scales back to v exactly
+func decimalScale(v float64, digits uint8) (int64, bool) {
+	x := math.Round(v * pow10[digits])
+	if !(math.Abs(x) < 1<<53) {
+		return 0, false
+	}
+	n := int64(x)
+	return n, math.Float64bits(float64(n)/pow10[digits]) == math.Float64bits(v)
+}
+
+// decimalBase returns the integer deltas at the given digits are taken from
+func decimalBase(prev float64, digits uint8) int64 {
+	x := math.Round(prev * pow10[digits])
+	if !(math.Abs(x) < 1<<53) {
+		return 0
+	}
+	return int64(x)
+}
+
+// decimalDigits returns the fewest fractional digits up to max that v can be
+// scaled by
+func decimalDigits(v float64, max uint8) (uint8, bool) {
+	for d := uint8(0); d <= max; d++ {
+		if _, ok := decimalScale(v, d); ok {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// decimalBucketBits returns the number of bits it takes to store a zigzag
+// encoded delta, including the control bits
+func decimalBucketBits(delta uint64) int {
+	if delta < 1<<uint(intBuckets[2]) {
+		return intBucketBits(delta)
+	}
+	return 4 + 6 + bits.Len64(delta)
+}
+
+// writeDecimalBucket writes a zigzag encoded delta
+func (s *Series) writeDecimalBucket(delta uint64) {
+	if delta < 1<<uint(intBuckets[2]) {
+		s.writeIntBucket(delta)
+		return
+	}
+
+	// 64 bits are written as 0
+	n := bits.Len64(delta)
+	s.bw.writeBits(0x0f, 4) // '1111'
+	s.bw.writeBits(uint64(n), 6)
+	s.bw.writeBits(delta, n)
+}
+
+// readDecimalBucket reads a zigzag encoded delta
+func (it *Iter) readDecimalBucket() (int64, error) {
+	d, err := it.readControl()
+	if err != nil || d == 0 {
+		return 0, err
+	}
+
+	var n uint64 = 64
+	if d < 4 {
+		n = uint64(intBuckets[d-1])
+	} else {
+		n, err = it.br.readBits(6)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			n = 64
+		}
+	}
+	delta, err := it.br.readBits(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return unzigzag(delta), nil
+}
+
+// xorBits returns the number of bits pushXOR takes to store v, which
+// differs from the previous value by xor
+func (s *Series) xorBits(v float64, xor uint64) int {
+	leading, trailing := s.fmt.xorWindow(xor)
+	if !s.resetWindow(v, leading, trailing) {
+		return 2 + 64 - int(s.leading) - int(s.trailing)
+	}
+	return 2 + s.fmt.windowBits() + 64 - int(leading) - int(trailing)
+}
+
+func (s *Series) pushDecimal(v float64) {
+	xor := math.Float64bits(v) ^ math.Float64bits(s.val)
+	if xor == 0 {
+		s.bw.writeBit(zero)
+		return
+	}
+
+	// the cheapest of the current digits, the fewest digits and an exception
+	best := 2 + s.xorBits(v, xor)
+
+	var delta uint64
+	digits, scaled := s.digits, false
+	max := uint8(maxDigits)
+	if n, ok := decimalScale(v, s.digits); ok {
+		delta = zigzag(n - decimalBase(s.val, s.digits))
+		if b := 2 + decimalBucketBits(delta); b < best {
+			best, scaled = b, true
+		}
+		max = s.digits
+	}
+	if d, ok := decimalDigits(v, max); ok && d != s.digits {
+		n, _ := decimalScale(v, d)
+		u := zigzag(n - decimalBase(s.val, d))
+		if b := 3 + 4 + decimalBucketBits(u); b < best {
+			best, scaled = b, true
+			digits, delta = d, u
+		}
+	}
+
+	switch {
+	case scaled && digits == s.digits:
+		s.bw.writeBits(0x02, 2) // '10'
+		s.writeDecimalBucket(delta)
+	case scaled:
+		s.bw.writeBits(0x06, 3) // '110'
+		s.bw.writeBits(uint64(digits), 4)
+		s.writeDecimalBucket(delta)
+		s.digits = digits
+	default:
+		s.bw.writeBits(0x03, 2) // '11'
+		s.pushXOR(v)
+	}
+}
+
+// readDecimal decodes a value of a decimal series
+func (it *Iter) readDecimal() error {
+	bit, err := it.br.readBit()
+	if err != nil || bit == zero {
+		return err
+	}
+
+	bit, err = it.br.readBit()
+	if err != nil {
+		return err
+	}
+	if bit == one {
+		bit, err = it.br.readBit()
+		if err != nil {
+			return err
+		}
+		if bit == one {
+			// the '1' of a changed XOR value
+			return it.readXORChanged()
+		}
+
+		digits, err := it.br.readBits(4)
+		if err != nil {
+			return err
+		}
+		if digits > maxDigits {
+			return ErrCorrupt
+		}
+		it.digits = uint8(digits)
+	}
+
+	delta, err := it.readDecimalBucket()
+	if err != nil {
+		return err
+	}
+	n := decimalBase(it.val, it.digits) + delta
+	if n <= -(1<<53) || n >= 1<<53 {
+		return ErrCorrupt
+	}
+	it.val = float64(n) / pow10[it.digits]
+	return nil
+}
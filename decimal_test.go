@@ -0,0 +1,38 @@
+package tsz
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecimalMarshalDigits(t *testing.T) {
+	s, s2 := marshalHalf(t, testValues(), WithDecimal())
+	if s2.digits != s.digits {
+		t.Errorf("unmarshaled series has %d digits, want %d", s2.digits, s.digits)
+	}
+}
+
+func TestDecimalDigits(t *testing.T) {
+	for _, tt := range []struct {
+		v      float64
+		digits uint8
+		ok     bool
+	}{
+		{0, 0, true},
+		{-3, 0, true},
+		{0.5, 1, true},
+		{-20.25, 2, true},
+		{0.1, 1, true},
+		{0.30000000000000004, 0, false},
+		{1e-15, 15, true},
+		{1 << 53, 0, false},
+		{math.Copysign(0, -1), 0, false},
+		{math.NaN(), 0, false},
+		{math.Inf(1), 0, false},
+	} {
+		d, ok := decimalDigits(tt.v, maxDigits)
+		if d != tt.digits || ok != tt.ok {
+			t.Errorf("decimalDigits(%v)=%d, %v; want %d, %v", tt.v, d, ok, tt.digits, tt.ok)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"strings"
 	"text/tabwriter"
 )
 
@@ -65,6 +66,9 @@ var LargeTestDataPos0f = make([]testdata.Point, 60*24)
 var LargeTestDataf = make([]testdata.Point, 60*24)
 var LargeTestData0f = make([]testdata.Point, 60*24)
 
+var SlowCounterf = make([]testdata.Point, 60*24)
+var SlowWalkf = make([]testdata.Point, 60*24)
+
 func main() {
 	for i := 0; i < 60*24; i++ {
 		ts := uint32(i * 60)
@@ -142,37 +146,16 @@ func main() {
 
 		LargeTestDataPos0f[i] = testdata.Point{math.Floor(LargeTestDataPosf[i].V), ts} // 0-maxfloat/1000
 		LargeTestData0f[i] = testdata.Point{math.Floor(LargeTestDataf[i].V), ts}       // -mf/1000 ~ mx/1000
-	}
 
-	intervals := []int{10, 30, 60, 120, 360, 720, 1440}
-	do := func(data []testdata.Point, comment string) string {
-		str := ""
-		for _, points := range intervals {
-			s := tsz.New(data[0].T)
-			for _, tt := range data[0:points] {
-				s.Push(tt.T, tt.V)
-			}
-			size := len(s.Bytes())
-			BPerPoint := float64(size) / float64(points)
-			str += fmt.Sprintf("\033[31m%d\033[39m\t%.2f\t", size, BPerPoint)
+		if i == 0 {
+			SlowCounterf[i] = testdata.Point{1e9, ts}
+			SlowWalkf[i] = testdata.Point{1e9, ts}
+		} else {
+			SlowCounterf[i] = testdata.Point{SlowCounterf[i-1].V + rand.ExpFloat64()*0.1, ts} // grows by about 0.1 a point
+			SlowWalkf[i] = testdata.Point{SlowWalkf[i-1].V + rand.NormFloat64(), ts}          // moves by about 1 a point
 		}
-		str += comment + "\t"
-		return str
-	}
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.AlignRight)
-	fmt.Println("=== help ===")
-	fmt.Println("CS = chunk size in Bytes")
-	fmt.Println("BPP = Bytes per point (CS/num-points)")
-	fmt.Println("d = integers stored as float64")
-	fmt.Println("f = float64's with a bunch of decimal numbers")
-	fmt.Println(".Xf = float64's with X decimal numbers")
-	fmt.Println("[num1] a - b [num2]: a range between a and b with the occasional outliers up to num1 and num2")
-	fmt.Println("=== data ===")
-	str := "test"
-	for _, points := range intervals {
-		str += fmt.Sprintf("\t  \033[39m%dCS\033[39m\t%dBPP", points, points)
 	}
+
 	cmtTinyPos := "0 ~ 10 [inf]"
 	cmtTinyPosNeg := "[-inf] -10 ~ 10 [inf]"
 	cmtSmallPos := "0 ~ 1000 [inf]"
@@ -185,60 +168,283 @@ func main() {
 	cmtRandomLargePosNeg := "[-inf] -MaxFloat64/1000 ~ MaxFloat64/1000 [inf]"
 	cmtLargeTestPos := "0 ~ MaxFloat64/1000"
 	cmtLargeTestPosNeg := "-MaxFloat64/1000 ~ MaxFloat64/1000"
+	cmtSlow := "1e9, slowly varying"
+
+	// a dataset without a name separates groups of rows
+	datasets := []dataset{
+		{"constant zero            d", ConstantZero, ""},
+		{"constant one             d", ConstantOne, ""},
+		{"constant pos           .3f", ConstantPos3f, ""},
+		{"constant neg           .3f", ConstantNeg3f, ""},
+		{"constant pos           .0f", ConstantPos0f, ""},
+		{"constant neg           .0f", ConstantNeg0f, ""},
+		{"constant nearmax         f", ConstantNearMaxf, ""},
+		{"constant nearmin         f", ConstantNearMinf, ""},
+		{"constant nearmax       .0f", ConstantNearMax0f, ""},
+		{"constant nearmin       .0f", ConstantNearMin0f, ""},
+		{"batch100 zero/one        d", Batch100ZeroOne, ""},
+		{"flapping zero/one        d", FlappingZeroOne, ""},
+		{},
+		{"random tiny pos       f", RandomTinyPosf, cmtTinyPos},
+		{"random tiny pos/neg   f", RandomTinyf, cmtTinyPosNeg},
+		{"random tiny pos     .2f", RandomTinyPos2f, cmtTinyPos},
+		{"random tiny pos/neg .2f", RandomTiny2f, cmtTinyPosNeg},
+		{"random tiny pos     .1f", RandomTinyPos1f, cmtTinyPos},
+		{"random tiny pos/neg .1f", RandomTiny1f, cmtTinyPosNeg},
+		{"random tiny pos     .0f", RandomTinyPos0f, cmtTinyPos},
+		{"random tiny pos/neg .0f", RandomTiny0f, cmtTinyPosNeg},
+		{},
+		{"testdata small pos       f", SmallTestDataPosf, cmtSmallTestPos},
+		{"testdata small pos/neg   f", SmallTestDataf, cmtSmallTestPosNeg},
+		{"testdata small pos     .0f", SmallTestDataPos0f, cmtSmallTestPos},
+		{"testdata small pos/neg .0f", SmallTestData0f, cmtSmallTestPosNeg},
+		{},
+		{"random small pos        f", RandomSmallPosf, cmtSmallPos},
+		{"random small pos/neg    f", RandomSmallf, cmtSmallPosNeg},
+		{"random small pos      .2f", RandomSmallPos2f, cmtSmallPos},
+		{"random small pos/neg  .2f", RandomSmall2f, cmtSmallPosNeg},
+		{"random small pos      .1f", RandomSmallPos1f, cmtSmallPos},
+		{"random small pos/neg  .1f", RandomSmall1f, cmtSmallPosNeg},
+		{"random small pos      .0f", RandomSmallPos0f, cmtSmallPos},
+		{"random small pos/neg  .0f", RandomSmall0f, cmtSmallPosNeg},
+		{},
+		{"random medium pos       f", Random60kPosf, cmt60kPos},
+		{"random medium pos/neg   f", Random60kf, cmt60kPosNeg},
+		{"random medium pos     .2f", Random60kPos2f, cmt60kPos},
+		{"random medium pos/neg .2f", Random60k2f, cmt60kPosNeg},
+		{"random medium pos     .1f", Random60kPos1f, cmt60kPos},
+		{"random medium pos/neg .1f", Random60k1f, cmt60kPosNeg},
+		{"random medium pos     .0f", Random60kPos0f, cmt60kPos},
+		{"random medium pos/neg .0f", Random60k0f, cmt60kPosNeg},
+		{},
+		{"testdata large pos       f", LargeTestDataPosf, cmtLargeTestPos},
+		{"testdata large pos/neg   f", LargeTestDataf, cmtLargeTestPosNeg},
+		{"testdata large pos     .0f", LargeTestDataPos0f, cmtLargeTestPos},
+		{"testdata large pos/neg .0f", LargeTestData0f, cmtLargeTestPosNeg},
+		{},
+		{"random large pos        f", RandomLargePosf, cmtRandomLargePos},
+		{"random large pos/neg    f", RandomLargef, cmtRandomLargePosNeg},
+		{"random large pos      .0f", RandomLargePos0f, cmtRandomLargePos},
+		{"random large pos/neg  .0f", RandomLarge0f, cmtRandomLargePosNeg},
+		{},
+		{"slow large counter      f", SlowCounterf, cmtSlow},
+		{"slow large walk         f", SlowWalkf, cmtSlow},
+	}
+
+	fmt.Println("=== help ===")
+	fmt.Println("CS = chunk size in Bytes")
+	fmt.Println("BPP = Bytes per point (CS/num-points)")
+	fmt.Println("d = integers stored as float64")
+	fmt.Println("f = float64's with a bunch of decimal numbers")
+	fmt.Println(".Xf = float64's with X decimal numbers")
+	fmt.Println("[num1] a - b [num2]: a range between a and b with the occasional outliers up to num1 and num2")
+	fmt.Println("sizes leave out the block header of encodings that need one")
+
+	fmt.Println("=== data ===")
+	table(datasets, floats())
+
+	// the integer datasets whose values fit in an int64
+	fmt.Println("=== IntSeries ===")
+	var integers []dataset
+	for _, d := range datasets {
+		if d.name == "" || isInt(d.data) {
+			integers = append(integers, d)
+		}
+	}
+	table(integers, ints)
+
+	fmt.Println("=== WithIntegerRuns ===")
+	table(datasets, floats(tsz.WithIntegerRuns()))
+
+	fmt.Println("=== codecs, BPP of 1440 points ===")
+	compare(datasets, []codec{
+		{"XOR", floats()},
+		{"integer runs", floats(tsz.WithIntegerRuns())},
+		{"Chimp", floats(tsz.WithChimp())},
+		{"Chimp128", floats(tsz.WithChimp128())},
+		{"decimal", floats(tsz.WithDecimal())},
+	})
+
+	// points held back for lookahead are only written by Finish
+	fmt.Println("=== XOR windows, BPP of 1440 finished points ===")
+	compare(datasets, []codec{
+		{"reuse", finished(tsz.WithWindowReuse())},
+		{"cost", finished()},
+		{"lookahead 4", finished(tsz.WithLookahead(4))},
+		{"lookahead 16", finished(tsz.WithLookahead(16))},
+	})
+
+	fmt.Println("=== leading zeros of XOR windows, BPP of 1440 points ===")
+	compare(datasets, []codec{
+		{"5 bits", floats(tsz.WithHeader())},
+		{"6 bits", floats(tsz.WithWideLeading())},
+	})
+
+	fmt.Println("=== WithRelativeError, BPP of 1440 points ===")
+	bounds := []float64{1e-6, 1e-4, 1e-3, 1e-2}
+	codecs := []codec{{"exact", floats()}}
+	for _, b := range bounds {
+		codecs = append(codecs, codec{fmt.Sprintf("%.4g%%", b*100), floats(tsz.WithRelativeError(b))})
+	}
+	compare(datasets, codecs)
+
+	fmt.Println("=== WithRelativeError, ratio against error over all datasets ===")
+	lossy(datasets, bounds)
+}
+
+// lossy prints the compression ratio over every dataset at each relative
+// error bound, and the largest error actually seen
+func lossy(datasets []dataset, bounds []float64) {
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "bound\tBPP\tratio\tlargest error\t")
+
+	for _, b := range append([]float64{0}, bounds...) {
+		var opts []tsz.Option
+		if b != 0 {
+			opts = append(opts, tsz.WithRelativeError(b))
+		}
+		encode := floats(opts...)
+
+		var points, size int
+		var worst float64
+		for _, d := range datasets {
+			if d.name == "" {
+				continue
+			}
+			points += len(d.data)
+			size += encode(d.data)
+
+			s := tsz.New(d.data[0].T, opts...)
+			for _, p := range d.data {
+				s.Push(p.T, p.V)
+			}
+			it := s.Iter()
+			for i := 0; it.Next(); i++ {
+				_, v := it.Values()
+				if want := d.data[i].V; want != 0 {
+					worst = math.Max(worst, math.Abs(v-want)/math.Abs(want))
+				}
+			}
+		}
+
+		// a raw point is a 32-bit timestamp and a float64
+		bpp := float64(size) / float64(points)
+		fmt.Fprintf(w, "%.4g%%\t%.2f\t%.1f\t%.2g%%\t\n", b*100, bpp, 12/bpp, worst*100)
+	}
+	w.Flush()
+}
+
+type codec struct {
+	name   string
+	encode encoder
+}
+
+// compare prints the size of every dataset in each codec side by side
+func compare(datasets []dataset, codecs []codec) {
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.AlignRight)
+
+	str := "test"
+	for _, c := range codecs {
+		str += "\t" + c.name
+	}
+	fmt.Fprintln(w, str+"\tcomment\t")
+
+	for _, d := range datasets {
+		if d.name == "" {
+			fmt.Fprintln(w, strings.Repeat("\t", len(codecs)+2))
+			continue
+		}
+
+		str := d.name
+		for _, c := range codecs {
+			str += fmt.Sprintf("\t%.2f", float64(c.encode(d.data))/float64(len(d.data)))
+		}
+		fmt.Fprintln(w, str+"\t"+d.comment+"\t")
+	}
+	w.Flush()
+}
+
+type dataset struct {
+	name    string
+	data    []testdata.Point
+	comment string
+}
+
+var intervals = []int{10, 30, 60, 120, 360, 720, 1440}
+
+// an encoder returns the size of data encoded
+type encoder func(data []testdata.Point) int
+
+// floats encodes data in a Series created with opts
+func floats(opts ...tsz.Option) encoder {
+	// the size of the header the options may add
+	header := len(tsz.New(0, opts...).Bytes()) - len(tsz.New(0).Bytes())
+
+	return func(data []testdata.Point) int {
+		s := tsz.New(data[0].T, opts...)
+		for _, tt := range data {
+			s.Push(tt.T, tt.V)
+		}
+		return len(s.Bytes()) - header
+	}
+}
+
+// finished encodes data in a finished Series created with opts
+func finished(opts ...tsz.Option) encoder {
+	return func(data []testdata.Point) int {
+		s := tsz.New(data[0].T, opts...)
+		for _, tt := range data {
+			s.Push(tt.T, tt.V)
+		}
+		s.Finish()
+		return len(s.Bytes())
+	}
+}
+
+// ints encodes data in an IntSeries
+func ints(data []testdata.Point) int {
+	s := tsz.NewInt(data[0].T)
+	for _, tt := range data {
+		s.Push(int64(tt.T), int64(tt.V))
+	}
+	return len(s.Bytes())
+}
+
+// isInt reports whether every value of data is an int64
+func isInt(data []testdata.Point) bool {
+	for _, p := range data {
+		if p.V != math.Trunc(p.V) || p.V < math.MinInt64 || p.V >= math.MaxInt64 {
+			return false
+		}
+	}
+	return true
+}
+
+func table(datasets []dataset, encode encoder) {
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.AlignRight)
+
+	str := "test"
+	for _, points := range intervals {
+		str += fmt.Sprintf("\t  \033[39m%dCS\033[39m\t%dBPP", points, points)
+	}
 	fmt.Fprintln(w, str+"\tcomment\t")
-	fmt.Fprintln(w, "constant zero            d\t"+do(ConstantZero, ""))
-	fmt.Fprintln(w, "constant one             d\t"+do(ConstantOne, ""))
-	fmt.Fprintln(w, "constant pos           .3f\t"+do(ConstantPos3f, ""))
-	fmt.Fprintln(w, "constant neg           .3f\t"+do(ConstantNeg3f, ""))
-	fmt.Fprintln(w, "constant pos           .0f\t"+do(ConstantPos0f, ""))
-	fmt.Fprintln(w, "constant neg           .0f\t"+do(ConstantNeg0f, ""))
-	fmt.Fprintln(w, "constant nearmax         f\t"+do(ConstantNearMaxf, ""))
-	fmt.Fprintln(w, "constant nearmin         f\t"+do(ConstantNearMinf, ""))
-	fmt.Fprintln(w, "constant nearmax       .0f\t"+do(ConstantNearMax0f, ""))
-	fmt.Fprintln(w, "constant nearmin       .0f\t"+do(ConstantNearMin0f, ""))
-	fmt.Fprintln(w, "batch100 zero/one        d\t"+do(Batch100ZeroOne, ""))
-	fmt.Fprintln(w, "flapping zero/one        d\t"+do(FlappingZeroOne, ""))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "random tiny pos       f\t"+do(RandomTinyPosf, cmtTinyPos))
-	fmt.Fprintln(w, "random tiny pos/neg   f\t"+do(RandomTinyf, cmtTinyPosNeg))
-	fmt.Fprintln(w, "random tiny pos     .2f\t"+do(RandomTinyPos2f, cmtTinyPos))
-	fmt.Fprintln(w, "random tiny pos/neg .2f\t"+do(RandomTiny2f, cmtTinyPosNeg))
-	fmt.Fprintln(w, "random tiny pos     .1f\t"+do(RandomTinyPos1f, cmtTinyPos))
-	fmt.Fprintln(w, "random tiny pos/neg .1f\t"+do(RandomTiny1f, cmtTinyPosNeg))
-	fmt.Fprintln(w, "random tiny pos     .0f\t"+do(RandomTinyPos0f, cmtTinyPos))
-	fmt.Fprintln(w, "random tiny pos/neg .0f\t"+do(RandomTiny0f, cmtTinyPosNeg))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "testdata small pos       f\t"+do(SmallTestDataPosf, cmtSmallTestPos))
-	fmt.Fprintln(w, "testdata small pos/neg   f\t"+do(SmallTestDataf, cmtSmallTestPosNeg))
-	fmt.Fprintln(w, "testdata small pos     .0f\t"+do(SmallTestDataPos0f, cmtSmallTestPos))
-	fmt.Fprintln(w, "testdata small pos/neg .0f\t"+do(SmallTestData0f, cmtSmallTestPosNeg))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "random small pos        f\t"+do(RandomSmallPosf, cmtSmallPos))
-	fmt.Fprintln(w, "random small pos/neg    f\t"+do(RandomSmallf, cmtSmallPosNeg))
-	fmt.Fprintln(w, "random small pos      .2f\t"+do(RandomSmallPos2f, cmtSmallPos))
-	fmt.Fprintln(w, "random small pos/neg  .2f\t"+do(RandomSmall2f, cmtSmallPosNeg))
-	fmt.Fprintln(w, "random small pos      .1f\t"+do(RandomSmallPos1f, cmtSmallPos))
-	fmt.Fprintln(w, "random small pos/neg  .1f\t"+do(RandomSmall1f, cmtSmallPosNeg))
-	fmt.Fprintln(w, "random small pos      .0f\t"+do(RandomSmallPos0f, cmtSmallPos))
-	fmt.Fprintln(w, "random small pos/neg  .0f\t"+do(RandomSmall0f, cmtSmallPosNeg))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "random medium pos       f\t"+do(Random60kPosf, cmt60kPos))
-	fmt.Fprintln(w, "random medium pos/neg   f\t"+do(Random60kf, cmt60kPosNeg))
-	fmt.Fprintln(w, "random medium pos     .2f\t"+do(Random60kPos2f, cmt60kPos))
-	fmt.Fprintln(w, "random medium pos/neg .2f\t"+do(Random60k2f, cmt60kPosNeg))
-	fmt.Fprintln(w, "random medium pos     .1f\t"+do(Random60kPos1f, cmt60kPos))
-	fmt.Fprintln(w, "random medium pos/neg .1f\t"+do(Random60k1f, cmt60kPosNeg))
-	fmt.Fprintln(w, "random medium pos     .0f\t"+do(Random60kPos0f, cmt60kPos))
-	fmt.Fprintln(w, "random medium pos/neg .0f\t"+do(Random60k0f, cmt60kPosNeg))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "testdata large pos       f\t"+do(LargeTestDataPosf, cmtLargeTestPos))
-	fmt.Fprintln(w, "testdata large pos/neg   f\t"+do(LargeTestDataf, cmtLargeTestPosNeg))
-	fmt.Fprintln(w, "testdata large pos     .0f\t"+do(LargeTestDataPos0f, cmtLargeTestPos))
-	fmt.Fprintln(w, "testdata large pos/neg .0f\t"+do(LargeTestData0f, cmtLargeTestPosNeg))
-	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
-	fmt.Fprintln(w, "random large pos        f\t"+do(RandomLargePosf, cmtRandomLargePos))
-	fmt.Fprintln(w, "random large pos/neg    f\t"+do(RandomLargef, cmtRandomLargePosNeg))
-	fmt.Fprintln(w, "random large pos      .0f\t"+do(RandomLargePos0f, cmtRandomLargePos))
-	fmt.Fprintln(w, "random large pos/neg  .0f\t"+do(RandomLarge0f, cmtRandomLargePosNeg))
+
+	for _, d := range datasets {
+		if d.name == "" {
+			fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t\t\t\t\t\t\t")
+			continue
+		}
+
+		str := d.name + "\t"
+		for _, points := range intervals {
+			size := encode(d.data[0:points])
+			BPerPoint := float64(size) / float64(points)
+			str += fmt.Sprintf("\033[31m%d\033[39m\t%.2f\t", size, BPerPoint)
+		}
+		fmt.Fprintln(w, str+d.comment+"\t")
+	}
 	w.Flush()
 }
@@ -0,0 +1,206 @@
+package tsz
+
+import "math"
+
+// A series may drop points that the points it keeps reconstruct within a
+// tolerance, recorded in the header after the error bound:
+//
+//	tolerance  64 bits  float64
+//
+// A deadband filter drops every point within the tolerance of the last point
+// kept, which the dropped points are reconstructed as.  A swinging door
+// filter drops every point within the tolerance of the line between the
+// points kept before and after it, which they are interpolated on.
+//
+// The last point pushed is held back until a later point shows whether it
+// is kept, so that the series always ends with it.  Held points are written
+// by Finish, and aren't seen by iterators until then.
+
+// filterKind identifies how a series drops points
+type filterKind uint8
+
+const (
+	filterNone filterKind = iota
+	filterDeadband
+	filterSwingingDoor
+)
+
+// WithDeadband drops every point whose value is within tolerance of the last
+// point kept.  It implies WithHeader, and is ignored by integer series.
+func WithDeadband(tolerance float64) Option {
+	return withFilter(filterDeadband, tolerance)
+}
+
+// WithSwingingDoor drops every point that a straight line between the points
+// kept around it passes within tolerance of, which suits series that are
+// flat or linear for long stretches.  It implies WithHeader, and is ignored
+// by integer series.
+func WithSwingingDoor(tolerance float64) Option {
+	return withFilter(filterSwingingDoor, tolerance)
+}
+
+func withFilter(k filterKind, tolerance float64) Option {
+	if !(tolerance > 0) || math.IsInf(tolerance, 1) {
+		panic("tsz: invalid filter tolerance")
+	}
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.filter, f.tolerance = k, tolerance
+	}
+}
+
+// Tolerance returns the tolerance of the filter that dropped points from the
+// series, or 0 if every point was kept
+func (it *Iter) Tolerance() float64 {
+	return it.fmt.tolerance
+}
+
+// heldPoint is the last point pushed to a filtered series, not yet written
+type heldPoint struct {
+	held bool
+	t    int64
+	v    float64
+
+	// the range of slopes from the last point kept that pass within the
+	// tolerance of every point dropped since
+	lo, hi float64
+}
+
+// filter pushes a point through the filter of the series
+func (s *Series) filter(t int64, v float64) error {
+	t, err := s.check(t)
+	if err != nil {
+		return err
+	}
+
+	// the last point kept
+	last, ok := s.last()
+
+	h := &s.hold
+	switch {
+	case h.held && t < h.t:
+		return ErrOutOfOrder
+	case !ok, s.afterNull():
+		// the first point, and the first after a null, are always kept
+		return s.push(t, v)
+	case !h.held:
+		*h = heldPoint{held: true, t: t, v: v, lo: math.Inf(-1), hi: math.Inf(1)}
+		return nil
+	}
+
+	var drop bool
+	switch s.fmt.filter {
+	case filterDeadband:
+		drop = math.Abs(h.v-last.V) <= s.fmt.tolerance
+	case filterSwingingDoor:
+		// the held point can be dropped if the line to the new point passes
+		// close enough to it and every point dropped before it
+		if dt := float64(h.t - last.T); dt > 0 && t > last.T {
+			lo := math.Max(h.lo, (h.v-s.fmt.tolerance-last.V)/dt)
+			hi := math.Min(h.hi, (h.v+s.fmt.tolerance-last.V)/dt)
+			slope := (v - last.V) / float64(t-last.T)
+			if lo <= slope && slope <= hi {
+				drop = true
+				h.lo, h.hi = lo, hi
+			}
+		}
+	}
+
+	if drop {
+		h.t, h.v = t, v
+		return nil
+	}
+
+	err = s.push(h.t, h.v)
+	*h = heldPoint{held: true, t: t, v: v, lo: math.Inf(-1), hi: math.Inf(1)}
+	return err
+}
+
+// Interpolate returns an iterator over the points of it with the points
+// dropped by its filter put back: after each point kept, one every step up to
+// the next point kept.  Points put back after a deadband hold the value of
+// the point before them, and are otherwise interpolated linearly.  Points put
+// back after a null point are NaN, as are those interpolated towards one.
+// Blocks without a filter are resampled the same way.
+func (it *Iter) Interpolate(step int64) *Interpolator {
+	if step <= 0 {
+		panic("tsz: invalid interpolation step")
+	}
+	return &Interpolator{it: it, step: step, hold: it.fmt.filter == filterDeadband}
+}
+
+// Interpolator iterates over a series with the points dropped by its filter
+// put back.  It is not concurrency-safe.
+type Interpolator struct {
+	it   *Iter
+	step int64
+	hold bool
+
+	// the points kept before and after the current one
+	t0, t1 int64
+	v0, v1 float64
+	next   bool // whether there is a point after
+
+	t       int64
+	v       float64
+	started bool
+}
+
+// Next moves to the next point, kept or put back
+func (ip *Interpolator) Next() bool {
+	if !ip.started {
+		ip.started = true
+		if !ip.it.Next() {
+			return false
+		}
+		ip.t0, ip.v0 = ip.it.Values64()
+		ip.t, ip.v = ip.t0, ip.v0
+		ip.advance()
+		return true
+	}
+
+	if !ip.next {
+		return false
+	}
+
+	if t := ip.t + ip.step; t < ip.t1 && t > ip.t {
+		ip.t = t
+		ip.v = ip.v0
+		if !ip.hold {
+			ip.v += (ip.v1 - ip.v0) * float64(t-ip.t0) / float64(ip.t1-ip.t0)
+		}
+		return true
+	}
+
+	ip.t0, ip.v0 = ip.t1, ip.v1
+	ip.t, ip.v = ip.t1, ip.v1
+	ip.advance()
+	return true
+}
+
+// advance reads the next point kept
+func (ip *Interpolator) advance() {
+	ip.next = ip.it.Next()
+	if ip.next {
+		ip.t1, ip.v1 = ip.it.Values64()
+	}
+}
+
+// Values at the current position
+func (ip *Interpolator) Values() (uint32, float64) {
+	return uint32(ip.t), ip.v
+}
+
+// Values64 returns the values at the current position with the full 64-bit
+// timestamp
+func (ip *Interpolator) Values64() (int64, float64) {
+	return ip.t, ip.v
+}
+
+// Err returns the error that stopped the underlying iterator, if any
+func (ip *Interpolator) Err() error {
+	return ip.it.Err()
+}
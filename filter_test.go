@@ -0,0 +1,196 @@
+package tsz
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// sensorData is flat or linear for long stretches, with a little noise
+func sensorData() []Point {
+	r := rand.New(rand.NewSource(5))
+	var points []Point
+	v, slope := 20.0, 0.0
+	for i := 0; i < 2000; i++ {
+		if i%100 == 0 {
+			slope = float64(r.Intn(3)-1) * r.Float64()
+		}
+		v += slope
+		points = append(points, Point{T: int64(i * 60), V: v + r.NormFloat64()*0.05})
+	}
+	return points
+}
+
+func TestFilterTolerance(t *testing.T) {
+	data := sensorData()
+	const tolerance = 0.5
+
+	for _, tt := range []struct {
+		opt Option
+		max int // most points kept
+	}{
+		// a deadband keeps most points of a ramp
+		{WithDeadband(tolerance), len(data) / 2},
+		{WithSwingingDoor(tolerance), len(data) / 20},
+	} {
+		s := New(0, tt.opt)
+		for _, p := range data {
+			if err := s.Push(uint32(p.T), p.V); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Finish()
+
+		it, err := NewIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.Tolerance() != tolerance {
+			t.Errorf("Tolerance()=%v, want %v", it.Tolerance(), tolerance)
+		}
+		kept := it.count
+
+		var n int
+		ip := it.Interpolate(60)
+		for ip.Next() {
+			ts, v := ip.Values64()
+			if n >= len(data) {
+				n++
+				continue
+			}
+			if ts != data[n].T {
+				t.Fatalf("filter=%v: point %d at %d, want %d", it.fmt.filter, n, ts, data[n].T)
+			}
+			if math.Abs(v-data[n].V) > tolerance*(1+1e-9) {
+				t.Errorf("filter=%v: point %d=%v, want within %v of %v", it.fmt.filter, n, v, tolerance, data[n].V)
+			}
+			n++
+		}
+		if n != len(data) || ip.Err() != nil {
+			t.Errorf("filter=%v: read %d points, err=%v; want %d, nil", it.fmt.filter, n, ip.Err(), len(data))
+		}
+		if kept > tt.max {
+			t.Errorf("filter=%v kept %d of %d points", it.fmt.filter, kept, len(data))
+		}
+	}
+}
+
+func TestFilterHeld(t *testing.T) {
+	s := New(0, WithSwingingDoor(1))
+	for i := 0; i < 10; i++ {
+		s.Push(uint32(i), float64(i))
+	}
+
+	// only the first point is written until the line breaks or the series
+	// is finished
+	var got []Point
+	for it := s.Iter(); it.Next(); {
+		ts, v := it.Values64()
+		got = append(got, Point{ts, v})
+	}
+	if len(got) != 1 || got[0] != (Point{0, 0}) {
+		t.Errorf("open series has %v, want [{0 0}]", got)
+	}
+
+	if err := s.Push(8, 1); err != ErrOutOfOrder {
+		t.Errorf("Push before the held point=%v, want %v", err, ErrOutOfOrder)
+	}
+
+	s.Finish()
+	got = got[:0]
+	for it := s.Iter(); it.Next(); {
+		ts, v := it.Values64()
+		got = append(got, Point{ts, v})
+	}
+	if len(got) != 2 || got[1] != (Point{9, 9}) {
+		t.Errorf("finished series has %v, want [{0 0} {9 9}]", got)
+	}
+}
+
+func TestFilterDeadbandHolds(t *testing.T) {
+	s := New(0, WithDeadband(1))
+	for i, v := range []float64{10, 10.5, 9.5, 10.9, 12, 12.2, 15} {
+		s.Push(uint32(i*10), v)
+	}
+	s.Finish()
+
+	it, _ := NewIterator(s.Bytes())
+	var got []float64
+	for ip := it.Interpolate(10); ip.Next(); {
+		_, v := ip.Values()
+		got = append(got, v)
+	}
+
+	want := []float64{10, 10, 10, 10, 12, 12, 15}
+	if len(got) != len(want) {
+		t.Fatalf("values=%v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("values=%v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestFilterMarshalBinary(t *testing.T) {
+	data := sensorData()
+	whole := New(0, WithSwingingDoor(0.5))
+	s := New(0, WithSwingingDoor(0.5))
+	for _, p := range data[:777] {
+		whole.Push(uint32(p.T), p.V)
+		s.Push(uint32(p.T), p.V)
+	}
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := New(0, WithSwingingDoor(0.5))
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range data[777:] {
+		whole.Push(uint32(p.T), p.V)
+		s2.Push(uint32(p.T), p.V)
+	}
+	whole.Finish()
+	s2.Finish()
+
+	if !bytes.Equal(whole.Bytes(), s2.Bytes()) {
+		t.Errorf("series continued after UnmarshalBinary differs")
+	}
+
+	b[0]++
+	if err := New(0, WithSwingingDoor(0.5)).UnmarshalBinary(b); err != ErrCorrupt {
+		t.Errorf("UnmarshalBinary(unknown version)=%v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	s := New(0)
+	s.Push(0, 0)
+	s.Push(40, 4)
+	s.Push(45, 10)
+	s.Push(100, 10)
+	s.Finish()
+
+	it, _ := NewIterator(s.Bytes())
+	var got []Point
+	for ip := it.Interpolate(20); ip.Next(); {
+		ts, v := ip.Values64()
+		got = append(got, Point{ts, v})
+	}
+
+	want := []Point{{0, 0}, {20, 2}, {40, 4}, {45, 10}, {65, 10}, {85, 10}, {100, 10}}
+	if len(got) != len(want) {
+		t.Fatalf("points=%v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("points=%v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package tsz
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A framed block starts with a header describing how the rest of it is
+// encoded, so that NewIterator can decode it without being told its format:
+//
+//	magic      32 bits  0xff 't' 's' 'z'
+//	version     8 bits
+//	flags      16 bits
+//	count      32 bits  number of points, filled in by Finish, all ones until
+//	                    then
+//	bound      64 bits  only with flagBound, see lossy.go
+//	tolerance  64 bits  only with a filter, see filter.go
+//	buckets    24 or 32 bits  only with other buckets, see buckets.go
+//	T0         32 or 64 bits
+//
+// Headerless blocks start directly with T0.  A headerless block is only
+// mistaken for a framed one if its T0 is 0xff74737a, some time in 2105.
+//
+// Version 1 blocks, like headerless ones, end their points with the '1111'
+// control code followed by a last bucket of all ones and a '0', which is
+// otherwise a delta-of-delta of -1 that only an encoder that doesn't pick the
+// narrowest bucket would write there.  Version 2 blocks put an escape bit
+// after '1111' instead, so that the end can't be mistaken for a point:
+//
+//	'1111' + '0' + delta-of-delta   a point
+//	'1111' + '1'                    the end of the stream
+//
+// Version 3 blocks also let the first point be any time after T0, with a
+// prefix before the delta between them:
+//
+//	'0' + delta                 in as many bits as the unit gives it
+//	'1' + delta 32 or 64 bits   as wide as a timestamp
+const (
+	magic = 0xff74737a
+
+	version1 = 1
+	version2 = 2 // the end of the stream is escaped
+	version3 = 3 // the first delta has a variable width
+
+	// byte offsets of the flags and the point count
+	flagsOffset = 5
+	countOffset = 7
+
+	// the point count of a block that isn't finished
+	countUnknown = 1<<32 - 1
+)
+
+// header flags
+const (
+	flagWide        = 1 << 0
+	flagUnitShift   = 1
+	flagUnitMask    = 3 << flagUnitShift
+	flagCodecShift  = 3
+	flagCodecMask   = 7 << flagCodecShift
+	flagChecksum    = 1 << 6
+	flagIndex       = 1 << 7
+	flagBound       = 1 << 8
+	flagRelative    = 1 << 9
+	flagFilterShift = 10
+	flagFilterMask  = 3 << flagFilterShift
+	flagWideLeading = 1 << 12
+	flagBucketShift = 13
+	flagBucketMask  = 3 << flagBucketShift
+	flagFirstNull   = 1 << 15
+
+	flagsKnown = flagWide | flagUnitMask | flagCodecMask | flagChecksum | flagIndex | flagBound | flagRelative | flagFilterMask | flagWideLeading | flagBucketMask | flagFirstNull
+)
+
+// ErrBadHeader is returned when a framed block has a header this package
+// can't decode
+var ErrBadHeader = errors.New("tsz: invalid block header")
+
+// valueCodec identifies how values are encoded
+type valueCodec uint8
+
+const (
+	codecXOR      valueCodec = iota // Gorilla XOR
+	codecInt                        // zigzag delta-of-delta integers
+	codecXORInt                     // Gorilla XOR with integer runs
+	codecChimp                      // Chimp
+	codecChimp128                   // Chimp128
+	codecDecimal                    // scaled decimals
+)
+
+// Option configures the encoding of a new series
+type Option func(*format)
+
+// WithHeader starts the series with a header recording its format and point
+// count.  Blocks with a header are decoded by NewIterator regardless of their
+// timestamp width or unit.
+func WithHeader() Option {
+	return func(f *format) {
+		f.framed = true
+	}
+}
+
+func (f format) flags() uint16 {
+	var u uint16
+	if f.wide {
+		u |= flagWide
+	}
+	u |= uint16(f.unit) << flagUnitShift
+	u |= uint16(f.codec) << flagCodecShift
+	if f.checksum {
+		u |= flagChecksum
+	}
+	if f.indexed {
+		u |= flagIndex
+	}
+	if f.bound != 0 {
+		u |= flagBound
+	}
+	if f.relative {
+		u |= flagRelative
+	}
+	u |= uint16(f.filter) << flagFilterShift
+	if f.wideLeading {
+		u |= flagWideLeading
+	}
+	switch {
+	case f.learn != 0:
+		u |= bucketsAdaptive << flagBucketShift
+	case f.buckets[0] != 0:
+		u |= bucketsTable << flagBucketShift
+	}
+	if f.firstNull {
+		u |= flagFirstNull
+	}
+	return u
+}
+
+func parseFlags(u uint16) (format, error) {
+	if u&^flagsKnown != 0 {
+		return format{}, ErrBadHeader
+	}
+
+	f := format{
+		framed:   true,
+		wide:     u&flagWide != 0,
+		unit:     Unit(u&flagUnitMask) >> flagUnitShift,
+		codec:    valueCodec(u&flagCodecMask) >> flagCodecShift,
+		checksum: u&flagChecksum != 0,
+		indexed:  u&flagIndex != 0,
+		relative: u&flagRelative != 0,
+		filter:   filterKind(u & flagFilterMask >> flagFilterShift),
+
+		wideLeading: u&flagWideLeading != 0,
+		firstNull:   u&flagFirstNull != 0,
+	}
+
+	if f.codec > codecDecimal {
+		return format{}, ErrBadHeader
+	}
+	if f.relative && u&flagBound == 0 {
+		return format{}, ErrBadHeader
+	}
+	if f.filter > filterSwingingDoor {
+		return format{}, ErrBadHeader
+	}
+	if f.wideLeading && !f.xorWindows() {
+		return format{}, ErrBadHeader
+	}
+	if f.firstNull && f.codec == codecInt {
+		return format{}, ErrBadHeader
+	}
+	if u&flagBucketMask>>flagBucketShift > bucketsAdaptive {
+		return format{}, ErrBadHeader
+	}
+
+	return f, nil
+}
+
+func writeHeader(w *bstream, f format) {
+	w.writeBits(magic, 32)
+	w.writeBits(uint64(f.version), 8)
+	w.writeBits(uint64(f.flags()), 16)
+	w.writeBits(countUnknown, 32)
+	if f.bound != 0 {
+		w.writeBits(math.Float64bits(f.bound), 64)
+	}
+	if f.filter != filterNone {
+		w.writeBits(math.Float64bits(f.tolerance), 64)
+	}
+	switch {
+	case f.learn != 0:
+		w.writeBits(uint64(f.learn), 32)
+	case f.buckets[0] != 0:
+		for _, b := range f.buckets {
+			w.writeBits(uint64(b), 8)
+		}
+	}
+}
+
+// writeFlags fills in the flags of a framed block again, once a null first
+// point is pushed
+func writeFlags(w *bstream, f format) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], f.flags())
+	w.patch(flagsOffset, b[:])
+}
+
+// writeCount fills in the point count of a framed block
+func writeCount(w *bstream, n int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	w.patch(countOffset, b[:])
+}
+
+// isFramed reports whether b starts with a block header
+func isFramed(b []byte) bool {
+	return len(b) >= 4 && binary.BigEndian.Uint32(b) == magic
+}
+
+// readHeader reads the header of a framed block, returning its format and
+// point count, or -1 if the block isn't finished
+func readHeader(br *breader) (format, int, error) {
+	u, err := br.readBits(32)
+	if err != nil {
+		return format{}, 0, err
+	}
+	if u != magic {
+		return format{}, 0, ErrBadHeader
+	}
+
+	v, err := br.readBits(8)
+	if err != nil {
+		return format{}, 0, err
+	}
+	if v < version1 || v > version3 {
+		return format{}, 0, ErrBadHeader
+	}
+
+	flags, err := br.readBits(16)
+	if err != nil {
+		return format{}, 0, err
+	}
+	f, err := parseFlags(uint16(flags))
+	if err != nil {
+		return format{}, 0, err
+	}
+	f.version = uint8(v)
+
+	n, err := br.readBits(32)
+	if err != nil {
+		return format{}, 0, err
+	}
+
+	if flags&flagBound != 0 {
+		u, err := br.readBits(64)
+		if err != nil {
+			return format{}, 0, err
+		}
+		f.bound = math.Float64frombits(u)
+		if !(f.bound > 0) || math.IsInf(f.bound, 1) {
+			return format{}, 0, ErrBadHeader
+		}
+	}
+
+	if f.filter != filterNone {
+		u, err := br.readBits(64)
+		if err != nil {
+			return format{}, 0, err
+		}
+		f.tolerance = math.Float64frombits(u)
+		if !(f.tolerance > 0) || math.IsInf(f.tolerance, 1) {
+			return format{}, 0, ErrBadHeader
+		}
+	}
+
+	switch flags & flagBucketMask >> flagBucketShift {
+	case bucketsTable:
+		var b [3]uint8
+		for i := range b {
+			u, err := br.readBits(8)
+			if err != nil {
+				return format{}, 0, err
+			}
+			b[i] = uint8(u)
+		}
+		if !validBuckets(b, f.ts().dod[3]) {
+			return format{}, 0, ErrBadHeader
+		}
+		f.buckets = b
+	case bucketsAdaptive:
+		u, err := br.readBits(32)
+		if err != nil {
+			return format{}, 0, err
+		}
+		if u == 0 {
+			return format{}, 0, ErrBadHeader
+		}
+		f.learn = int(u)
+	}
+
+	if n == countUnknown {
+		return f, -1, nil
+	}
+	return f, int(n), nil
+}
@@ -0,0 +1,251 @@
+package tsz
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+func TestHeaderRoundtrip(t *testing.T) {
+
+	tests := []struct {
+		s    *Series
+		unit Unit
+		mul  int64
+	}{
+		{New(testdata.TwoHoursData[0].T, WithHeader()), Second, 1},
+		{New64(int64(testdata.TwoHoursData[0].T), Second, WithHeader()), Second, 1},
+		{New64(int64(testdata.TwoHoursData[0].T)*1e3, Millisecond, WithHeader()), Millisecond, 1e3},
+		{New64(int64(testdata.TwoHoursData[0].T)*1e9, Nanosecond, WithHeader()), Nanosecond, 1e9},
+	}
+
+	for _, tt := range tests {
+		for _, p := range testdata.TwoHoursData {
+			tt.s.Push64(int64(p.T)*tt.mul, p.V)
+		}
+		tt.s.Finish()
+
+		b := tt.s.Bytes()
+		if !isFramed(b) {
+			t.Fatalf("unit=%v block has no header", tt.unit)
+		}
+
+		// the header tells NewIterator how to decode the block
+		it, err := NewIterator(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.Unit() != tt.unit {
+			t.Errorf("Unit()=%v, want %v", it.Unit(), tt.unit)
+		}
+		if it.count != len(testdata.TwoHoursData) {
+			t.Errorf("unit=%v header count=%d, want %d", tt.unit, it.count, len(testdata.TwoHoursData))
+		}
+		if it.Start() != tt.s.Start() {
+			t.Errorf("unit=%v Start()=%v, want %v", tt.unit, it.Start(), tt.s.Start())
+		}
+
+		for _, w := range testdata.TwoHoursData {
+			if !it.Next() {
+				t.Fatalf("unit=%v Next()=false, want true", tt.unit)
+			}
+			ts, vv := it.Values64()
+			if int64(w.T)*tt.mul != ts || w.V != vv {
+				t.Errorf("unit=%v Values64()=(%v,%v), want (%v,%v)\n", tt.unit, ts, vv, int64(w.T)*tt.mul, w.V)
+			}
+		}
+
+		if it.Next() {
+			t.Fatalf("unit=%v Next()=true, want false", tt.unit)
+		}
+		if err := it.Err(); err != nil {
+			t.Errorf("unit=%v it.Err()=%v, want nil", tt.unit, err)
+		}
+	}
+}
+
+func TestHeaderCountPending(t *testing.T) {
+	// the count is patched into the header while it's still in the accumulator
+	s := New(0, WithHeader())
+	s.Push(10, 1)
+	s.Finish()
+
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.count != 1 {
+		t.Errorf("header count=%d, want 1", it.count)
+	}
+	for it.Next() {
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("it.Err()=%v, want nil", err)
+	}
+}
+
+func TestHeaderEmpty(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithHeader()},
+		{WithIndex(4), WithChecksum()},
+		{WithChimp128(), WithAdaptiveBuckets(5)},
+	} {
+		s := New(10, opts...)
+		s.Finish()
+
+		it, err := NewIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if it.count != 0 {
+			t.Errorf("header count=%d, want 0", it.count)
+		}
+		if it.Next() {
+			t.Error("Next()=true on an empty block, want false")
+		}
+		if err := it.Err(); err != nil || !it.finished {
+			t.Errorf("it.Err()=%v, finished=%v; want nil, true", err, it.finished)
+		}
+	}
+
+	// the count of an open series is unknown, so its points are read
+	s := New(10, WithHeader())
+	s.Push(10, 1)
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.count != -1 || !it.Next() {
+		t.Errorf("open series: header count=%d, Next()=false; want -1, true", it.count)
+	}
+}
+
+func TestHeaderCountMismatch(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T, WithHeader())
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+
+	b := append([]byte(nil), s.Bytes()...)
+	b[countOffset+3]++
+
+	it, err := NewIterator(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+	}
+	if err := it.Err(); err != ErrCorrupt {
+		t.Errorf("it.Err()=%v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestBadHeader(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T, WithHeader())
+	s.Push(testdata.TwoHoursData[0].T, 1)
+	s.Finish()
+	good := s.Bytes()
+
+	for _, i := range []int{
+		4, // version
+		5, // reserved flag bits
+	} {
+		b := append([]byte(nil), good...)
+		b[i] = 0xff
+		if _, err := NewIterator(b); err != ErrBadHeader {
+			t.Errorf("corrupt byte %d: NewIterator()=%v, want %v", i, err, ErrBadHeader)
+		}
+	}
+}
+
+func TestHeaderless(t *testing.T) {
+	// blocks without a header still decode
+	s := New(testdata.TwoHoursData[0].T)
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+
+	if isFramed(s.Bytes()) {
+		t.Fatalf("headerless block detected as framed")
+	}
+
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for it.Next() {
+		n++
+	}
+	if n != len(testdata.TwoHoursData) || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(testdata.TwoHoursData))
+	}
+}
+
+// a version 1 block with a skip index every 2 points and a checksum, as
+// written before the end of the stream was escaped
+var legacyBlock = []byte{
+	0xff, 0x74, 0x73, 0x7a, 0x1, 0x0, 0xc0, 0x0, 0x0, 0x0, 0x6, 0x59, 0x68, 0x2f, 0x0, 0x0,
+	0xf0, 0xff, 0xc0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0xb0, 0x1b, 0x9, 0x9f, 0xff, 0xe0, 0x0,
+	0x30, 0xb6, 0x18, 0xf, 0x80, 0xb, 0xff, 0xff, 0xcf, 0x4a, 0x16, 0x0, 0x5b, 0x66, 0x1f, 0xea,
+	0x59, 0x24, 0xd6, 0x92, 0xca, 0x61, 0xbe, 0xff, 0xff, 0xff, 0xff, 0xf8, 0x0, 0x0, 0x0, 0xd5,
+	0x59, 0x68, 0x2f, 0x78, 0x0, 0x0, 0x0, 0x3c, 0x3f, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0xc, 0x33, 0x0, 0x0, 0x1, 0x2f, 0x59, 0x69, 0xb5, 0xa0, 0x0, 0x1, 0x85, 0xec, 0xc0, 0xa,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x31, 0x0, 0x0, 0x1, 0xb9, 0x59, 0x69, 0xb6, 0x4,
+	0x0, 0x0, 0x0, 0x28, 0x54, 0xb2, 0x49, 0xad, 0x25, 0x94, 0xc3, 0x7d, 0x1, 0x0, 0x0, 0x0,
+	0x0, 0x2, 0x0, 0x0, 0x0, 0x3, 0xe6, 0xf, 0xce, 0x60,
+}
+
+var legacyPoints = []Point{{1500000060, 1}, {1500000120, 1.5}, {1500000180, 2}, {1500100000, -3.25}, {1500100060, 0}, {1500100100, 1e100}}
+
+func TestLegacyVersion(t *testing.T) {
+	if err := Verify(legacyBlock); err != nil {
+		t.Fatalf("Verify()=%v", err)
+	}
+	points, err := Salvage(legacyBlock)
+	if err != nil || !reflect.DeepEqual(points, legacyPoints) {
+		t.Errorf("Salvage()=%v, %v; want %v, nil", points, err, legacyPoints)
+	}
+
+	it, _ := NewIterator(legacyBlock)
+	if !it.Seek(1500100060) {
+		t.Fatalf("Seek()=false, err=%v", it.Err())
+	}
+	if _, v := it.Values(); v != 0 {
+		t.Errorf("Seek() found %v, want 0", v)
+	}
+
+	// the same points in the current version
+	s := New(1500000000, WithIndex(2), WithChecksum())
+	for _, p := range legacyPoints {
+		s.Push(uint32(p.T), p.V)
+	}
+	s.Finish()
+	if b := s.Bytes(); b[4] != version3 || len(b) >= len(legacyBlock) {
+		t.Errorf("version %d block of %d bytes, want version %d and fewer than %d", b[4], len(b), version3, len(legacyBlock))
+	}
+}
+
+func TestEndEscaped(t *testing.T) {
+	// a delta-of-delta of -1 in the last bucket ended version 1 blocks
+	f := format{framed: true, version: version2}
+	var w bstream
+	writeHeader(&w, f)
+	w.writeBits(0, 32)
+	w.writeBits(60, 14)
+	w.writeBits(math.Float64bits(1), 64)
+	w.writeBits(0x0f, 4) // '1111'
+	w.writeBit(zero)
+	w.writeBits(0xffffffff, 32)
+	w.writeBit(zero) // the same value
+	seal(&w, f, 2, nil)
+
+	points, err := Salvage(w.bytes())
+	if want := []Point{{60, 1}, {119, 1}}; err != nil || !reflect.DeepEqual(points, want) {
+		t.Errorf("Salvage()=%v, %v; want %v, nil", points, err, want)
+	}
+}
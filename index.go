@@ -0,0 +1,270 @@
+package tsz
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// A block with a skip index has it between the end-of-stream record and the
+// checksum, starting on a byte boundary:
+//
+//	entries   one for every interval points
+//	interval  32 bits
+//	count     32 bits  number of entries
+//
+// Each entry holds the decoder state after a multiple of interval points, so
+// that decoding can resume from there:
+//
+//	offset    32 bits  offset in bits of the next point
+//	t         32 or 64 bits
+//	tDelta    32 or 64 bits
+//	val       64 bits
+//	leading    8 bits  0xff before the first window
+//	trailing   8 bits
+//
+// Entries of decimal series end with their number of fractional digits:
+//
+//	digits     8 bits
+//
+// Entries of integer series hold their values instead:
+//
+//	ival      64 bits
+//	ivDelta   64 bits
+//
+// The index ends a fixed distance from the end of the block, so it can be
+// found without decoding the points.
+type indexEntry struct {
+	offset            int
+	t, tDelta         int64
+	val               float64
+	leading, trailing uint8
+	ival, ivDelta     int64
+	digits            uint8
+}
+
+// WithIndex adds a skip index with an entry every interval points, letting
+// Seek jump close to its target instead of decoding every point before it.
+// It implies WithHeader.
+func WithIndex(interval int) Option {
+	if interval <= 0 {
+		panic("tsz: invalid index interval")
+	}
+	return func(f *format) {
+		f.framed = true
+		f.indexed = true
+		f.interval = interval
+	}
+}
+
+func (s *Series) indexEntry() indexEntry {
+	return indexEntry{
+		offset:   len(s.bw.stream)*8 + int(s.bw.count),
+		t:        s.t,
+		tDelta:   s.tDelta,
+		val:      s.val,
+		leading:  s.leading,
+		trailing: s.trailing,
+		ival:     s.ival,
+		ivDelta:  s.ivDelta,
+		digits:   s.digits,
+	}
+}
+
+func (it *Iter) indexEntry() indexEntry {
+	return indexEntry{
+		offset:   it.br.offset(),
+		t:        it.t,
+		tDelta:   it.tDelta,
+		val:      it.val,
+		leading:  it.leading,
+		trailing: it.trailing,
+		ival:     it.ival,
+		ivDelta:  it.ivDelta,
+		digits:   it.digits,
+	}
+}
+
+func entrySize(f format) int {
+	switch f.codec {
+	case codecInt:
+		return (32 + 2*f.t0Bits() + 128) / 8
+	case codecDecimal:
+		return (32 + 2*f.t0Bits() + 64 + 24) / 8
+	}
+	return (32 + 2*f.t0Bits() + 64 + 16) / 8
+}
+
+func writeIndex(w *bstream, f format, index []indexEntry) {
+	w.pad()
+	for _, e := range index {
+		w.writeBits(uint64(e.offset), 32)
+		w.writeBits(uint64(e.t), f.t0Bits())
+		w.writeBits(uint64(e.tDelta), f.t0Bits())
+		if f.codec == codecInt {
+			w.writeBits(uint64(e.ival), 64)
+			w.writeBits(uint64(e.ivDelta), 64)
+			continue
+		}
+		w.writeBits(math.Float64bits(e.val), 64)
+		w.writeBits(uint64(e.leading), 8)
+		w.writeBits(uint64(e.trailing), 8)
+		if f.codec == codecDecimal {
+			w.writeBits(uint64(e.digits), 8)
+		}
+	}
+	w.writeBits(uint64(f.interval), 32)
+	w.writeBits(uint64(len(index)), 32)
+}
+
+// indexBounds locates the skip index of a finished block, returning the byte
+// offsets of its first entry and of its end
+func indexBounds(b []byte, f format) (start, end int, err error) {
+	end = len(b)
+	if f.checksum {
+		end -= 4
+	}
+	if end < 8 {
+		return 0, 0, ErrCorrupt
+	}
+
+	count := int(binary.BigEndian.Uint32(b[end-4:]))
+	if count > (end-8)/entrySize(f) {
+		return 0, 0, ErrCorrupt
+	}
+
+	return end - 8 - count*entrySize(f), end, nil
+}
+
+// skipIndex moves br, positioned just after the end-of-stream record, past
+// the skip index
+func (it *Iter) skipIndex(br *breader) error {
+	start, end, err := indexBounds(br.stream, it.fmt)
+	if err != nil {
+		return err
+	}
+	if br.offset() != start*8 {
+		return ErrCorrupt
+	}
+	br.seek(end * 8)
+	return nil
+}
+
+// loadIndex reads the skip index, if the block has one
+func (it *Iter) loadIndex() {
+	it.indexLoaded = true
+
+	if !it.fmt.indexed || it.ring != nil {
+		// Chimp128 entries couldn't restore the ring
+		return
+	}
+
+	start, end, err := indexBounds(it.br.stream, it.fmt)
+	if err != nil {
+		// fall back to decoding every point
+		return
+	}
+
+	br := newBReader(it.br.stream[start:end])
+	index := make([]indexEntry, 0, (end-8-start)/entrySize(it.fmt))
+	for len(index) < cap(index) {
+		offset, _ := br.readBits(32)
+		t, _ := br.readBits(it.fmt.t0Bits())
+		tDelta, _ := br.readBits(it.fmt.t0Bits())
+		e := indexEntry{
+			offset: int(offset),
+			t:      int64(t),
+			tDelta: int64(tDelta),
+		}
+
+		if it.fmt.codec == codecInt {
+			ival, _ := br.readBits(64)
+			ivDelta, _ := br.readBits(64)
+			e.ival, e.ivDelta = int64(ival), int64(ivDelta)
+			e.val = float64(e.ival)
+		} else {
+			val, _ := br.readBits(64)
+			leading, _ := br.readBits(8)
+			trailing, _ := br.readBits(8)
+			// a window, or 0xff for none yet
+			if leading+trailing > 64 && leading != 0xff {
+				return
+			}
+			e.val = math.Float64frombits(val)
+			e.leading, e.trailing = uint8(leading), uint8(trailing)
+			if it.fmt.codec == codecDecimal {
+				digits, _ := br.readBits(8)
+				if digits > maxDigits {
+					return
+				}
+				e.digits = uint8(digits)
+			}
+		}
+
+		if e.offset > start*8 {
+			return
+		}
+		index = append(index, e)
+	}
+
+	interval, _ := br.readBits(32)
+	if interval == 0 {
+		return
+	}
+
+	it.index, it.interval = index, int(interval)
+}
+
+// Seek advances the iterator to the first point with a timestamp of at least
+// t.  If the current point is already there the iterator doesn't move.  It
+// returns false if there is no such point.
+func (it *Iter) Seek(t uint32) bool {
+	return it.Seek64(int64(t))
+}
+
+// Seek64 is Seek with a timestamp in the unit of the series
+func (it *Iter) Seek64(t int64) bool {
+	if it.err != nil || it.finished {
+		return false
+	}
+
+	if it.n > 0 && it.t >= t {
+		return true
+	}
+
+	if !it.indexLoaded {
+		it.loadIndex()
+	}
+
+	// the last entry before t, if it is ahead of us
+	i := sort.Search(len(it.index), func(i int) bool { return it.index[i].t >= t }) - 1
+	if i >= 0 && (i+1)*it.interval > it.n {
+		// entries don't hold the buckets learned by an adaptive series, so
+		// read them on the way
+		for it.n < it.fmt.learn {
+			if !it.Next() {
+				return false
+			}
+			if it.t >= t {
+				return true
+			}
+		}
+	}
+	if i >= 0 && (i+1)*it.interval > it.n {
+		e := it.index[i]
+		it.br.seek(e.offset)
+		it.t, it.tDelta, it.val = e.t, e.tDelta, e.val
+		it.leading, it.trailing = e.leading, e.trailing
+		it.ival, it.ivDelta = e.ival, e.ivDelta
+		it.digits = e.digits
+		it.n = (i + 1) * it.interval
+	}
+
+	for it.Next() {
+		if it.t >= t {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,252 @@
+package tsz
+
+import (
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+func indexedBlock(opts ...Option) []byte {
+	s := New(testdata.TwoHoursData[0].T, opts...)
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+	return s.Bytes()
+}
+
+// seekWant returns the index of the first point at or after t
+func seekWant(t uint32) int {
+	for i, p := range testdata.TwoHoursData {
+		if p.T >= t {
+			return i
+		}
+	}
+	return len(testdata.TwoHoursData)
+}
+
+func TestSeek(t *testing.T) {
+	data := testdata.TwoHoursData
+	blocks := map[string][]byte{
+		"plain":     indexedBlock(),
+		"index":     indexedBlock(WithIndex(8)),
+		"index+crc": indexedBlock(WithIndex(1), WithChecksum()),
+	}
+
+	for name, b := range blocks {
+		if err := Verify(b); err != nil {
+			t.Fatalf("%s: Verify()=%v", name, err)
+		}
+
+		for _, target := range []uint32{0, data[0].T, data[0].T + 1, data[17].T, data[17].T + 1, data[len(data)-1].T} {
+			it, _ := NewIterator(b)
+			want := seekWant(target)
+			if !it.Seek(target) {
+				t.Fatalf("%s: Seek(%d)=false, want true", name, target)
+			}
+			tt, vv := it.Values()
+			if w := data[want]; tt != w.T || vv != w.V {
+				t.Errorf("%s: Seek(%d) Values()=(%v,%v), want (%v,%v)", name, target, tt, vv, w.T, w.V)
+			}
+
+			// the rest of the block decodes as usual
+			n := want + 1
+			for it.Next() {
+				tt, vv := it.Values()
+				if w := data[n]; tt != w.T || vv != w.V {
+					t.Fatalf("%s: after Seek(%d) Values()=(%v,%v), want (%v,%v)", name, target, tt, vv, w.T, w.V)
+				}
+				n++
+			}
+			if n != len(data) || it.Err() != nil {
+				t.Errorf("%s: after Seek(%d) read to %d, err=%v; want %d, nil", name, target, n, it.Err(), len(data))
+			}
+		}
+
+		it, _ := NewIterator(b)
+		if it.Seek(data[len(data)-1].T + 1) {
+			t.Errorf("%s: Seek(past end)=true, want false", name)
+		}
+		if it.Err() != nil {
+			t.Errorf("%s: Seek(past end) err=%v, want nil", name, it.Err())
+		}
+	}
+}
+
+func TestSeekForwardOnly(t *testing.T) {
+	data := testdata.TwoHoursData
+	b := indexedBlock(WithIndex(4))
+
+	it, _ := NewIterator(b)
+	for i := 0; i < 50; i++ {
+		it.Next()
+	}
+	cur, _ := it.Values()
+
+	// seeking backwards leaves the iterator where it is
+	if !it.Seek(data[3].T) {
+		t.Fatal("Seek(behind)=false, want true")
+	}
+	if tt, _ := it.Values(); tt != cur {
+		t.Errorf("Seek(behind) moved to %d, want %d", tt, cur)
+	}
+
+	for _, i := range []int{51, 52, 90, 119} {
+		if !it.Seek(data[i].T) {
+			t.Fatalf("Seek(%d)=false, want true", data[i].T)
+		}
+		if tt, vv := it.Values(); tt != data[i].T || vv != data[i].V {
+			t.Errorf("Seek(%d) Values()=(%v,%v), want (%v,%v)", data[i].T, tt, vv, data[i].T, data[i].V)
+		}
+	}
+}
+
+func TestSeekEqualTimestamps(t *testing.T) {
+	s := New(0, WithIndex(2))
+	for i := 0; i < 20; i++ {
+		s.Push(uint32(i/5*60), float64(i))
+	}
+	s.Finish()
+
+	it, _ := NewIterator(s.Bytes())
+	if !it.Seek(120) {
+		t.Fatal("Seek(120)=false, want true")
+	}
+	// the first of the points sharing the timestamp
+	if tt, vv := it.Values(); tt != 120 || vv != 10 {
+		t.Errorf("Seek(120) Values()=(%v,%v), want (120,10)", tt, vv)
+	}
+}
+
+func TestSeekOpenSeries(t *testing.T) {
+	data := testdata.TwoHoursData
+	s := New(data[0].T, WithIndex(3))
+	for _, p := range data[:100] {
+		s.Push(p.T, p.V)
+	}
+
+	it := s.Iter()
+	if !it.Seek(data[70].T) {
+		t.Fatal("Seek()=false, want true")
+	}
+	if tt, vv := it.Values(); tt != data[70].T || vv != data[70].V {
+		t.Errorf("Values()=(%v,%v), want (%v,%v)", tt, vv, data[70].T, data[70].V)
+	}
+
+	// the series can still grow after it was read
+	for _, p := range data[100:] {
+		if err := s.Push(p.T, p.V); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSeek64(t *testing.T) {
+	const n = 1000
+	s := New64(1e15, Microsecond, WithIndex(16))
+	for i := int64(0); i < n; i++ {
+		s.Push64(1e15+i*1e6+i%7, float64(i))
+	}
+	s.Finish()
+
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int64{0, 15, 16, 17, 500, n - 1} {
+		if !it.Seek64(1e15 + i*1e6) {
+			t.Fatalf("Seek64(point %d)=false, want true", i)
+		}
+		if _, v := it.Values64(); v != float64(i) {
+			t.Errorf("Seek64(point %d) found %v", i, v)
+		}
+	}
+}
+
+func TestSeekWrapped(t *testing.T) {
+	// delta-of-deltas that wrap around in 32 bits
+	times := []uint32{0, 10, 1<<31 + 100, 1<<31 + 200, 1<<32 - 50, 1<<32 - 10}
+	for _, opts := range [][]Option{nil, {WithIndex(2)}} {
+		s := New(0, opts...)
+		for i, ts := range times {
+			if err := s.Push(ts, float64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Finish()
+
+		for i, ts := range times {
+			it, _ := NewIterator(s.Bytes())
+			if !it.Seek(ts) {
+				t.Fatalf("%d options: Seek(%d)=false, want true, err=%v", len(opts), ts, it.Err())
+			}
+			if got, v := it.Values(); got != ts || v != float64(i) {
+				t.Errorf("%d options: Seek(%d) found (%d, %v), want (%d, %d)", len(opts), ts, got, v, ts, i)
+			}
+		}
+	}
+}
+
+func TestIndexMarshalBinary(t *testing.T) {
+	data := testdata.TwoHoursData
+	s := New(data[0].T, WithIndex(5))
+	for _, p := range data[:50] {
+		s.Push(p.T, p.V)
+	}
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := New(0, WithIndex(5))
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range data[50:] {
+		s2.Push(p.T, p.V)
+	}
+	s2.Finish()
+
+	if got, want := s2.Bytes(), indexedBlock(WithIndex(5)); string(got) != string(want) {
+		t.Errorf("unmarshaled series wrote a different block")
+	}
+}
+
+func TestIndexCorrupt(t *testing.T) {
+	b := indexedBlock(WithIndex(8))
+
+	// a damaged entry count is noticed at the end of the stream, and Seek
+	// falls back to decoding every point
+	b[len(b)-1] ^= 0x10
+	if err := Verify(b); err == nil {
+		t.Error("Verify(bad index count)=nil, want error")
+	}
+
+	data := testdata.TwoHoursData
+	it, _ := NewIterator(b)
+	if !it.Seek(data[100].T) {
+		t.Fatal("Seek()=false, want true")
+	}
+	if tt, _ := it.Values(); tt != data[100].T {
+		t.Errorf("Seek() found %d, want %d", tt, data[100].T)
+	}
+}
+
+func BenchmarkSeek(b *testing.B) {
+	data := testdata.TwoHoursData
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{"plain", nil},
+		{"index", []Option{WithIndex(16)}},
+	} {
+		block := indexedBlock(bb.opts...)
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				it, _ := NewIterator(block)
+				it.Seek(data[len(data)-2].T)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package tsz
+
+// Integer series store their values the way timestamps are stored, as the
+// delta-of-delta from the previous value, zigzag encoded so that small
+// negative numbers stay small:
+//
+//	'0'                          dod == 0
+//	'10'   + intBuckets[0] bits
+//	'110'  + intBuckets[1] bits
+//	'1110' + intBuckets[2] bits
+//	'1111' + 64 bits
+//
+// Deltas wrap around on overflow, so every int64 is stored exactly.
+var intBuckets = [3]int{5, 11, 19}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// intBucketBits returns the number of bits it takes to store a zigzag
+// encoded delta-of-delta, including the control bits
+func intBucketBits(dod uint64) int {
+	switch {
+	case dod == 0:
+		return 1
+	case dod < 1<<uint(intBuckets[0]):
+		return 2 + intBuckets[0]
+	case dod < 1<<uint(intBuckets[1]):
+		return 3 + intBuckets[1]
+	case dod < 1<<uint(intBuckets[2]):
+		return 4 + intBuckets[2]
+	}
+	return 4 + 64
+}
+
+// writeIntBucket writes a zigzag encoded delta-of-delta
+func (s *Series) writeIntBucket(dod uint64) {
+	switch {
+	case dod == 0:
+		s.bw.writeBit(zero)
+	case dod < 1<<uint(intBuckets[0]):
+		s.bw.writeBits(0x02, 2) // '10'
+		s.bw.writeBits(dod, intBuckets[0])
+	case dod < 1<<uint(intBuckets[1]):
+		s.bw.writeBits(0x06, 3) // '110'
+		s.bw.writeBits(dod, intBuckets[1])
+	case dod < 1<<uint(intBuckets[2]):
+		s.bw.writeBits(0x0e, 4) // '1110'
+		s.bw.writeBits(dod, intBuckets[2])
+	default:
+		s.bw.writeBits(0x0f, 4) // '1111'
+		s.bw.writeBits(dod, 64)
+	}
+}
+
+// readIntBucket reads a zigzag encoded delta-of-delta
+func (it *Iter) readIntBucket() (int64, error) {
+	d, err := it.readControl()
+	if err != nil || d == 0 {
+		return 0, err
+	}
+
+	sz := 64
+	if d < 4 {
+		sz = intBuckets[d-1]
+	}
+	dod, err := it.br.readBits(sz)
+	if err != nil {
+		return 0, err
+	}
+	return unzigzag(dod), nil
+}
+
+// IntSeries is a series of integer values, such as counters and gauges.  It
+// is encoded like a Series, and is as safe for concurrent use.
+type IntSeries struct {
+	s *Series
+}
+
+// NewInt creates an integer series with 32-bit timestamps in seconds
+func NewInt(t0 uint32, opts ...Option) *IntSeries {
+	return &IntSeries{newSeries(int64(t0), format{codec: codecInt}, opts)}
+}
+
+// NewInt64 creates an integer series with 64-bit timestamps in the given unit
+func NewInt64(t0 int64, unit Unit, opts ...Option) *IntSeries {
+	if unit > Nanosecond {
+		panic("tsz: invalid unit")
+	}
+	return &IntSeries{newSeries(t0, format{wide: true, unit: unit, codec: codecInt}, opts)}
+}
+
+// Start of the series
+func (s *IntSeries) Start() int64 {
+	return s.s.Start()
+}
+
+// Bytes value of the series stream
+func (s *IntSeries) Bytes() []byte {
+	return s.s.Bytes()
+}
+
+// Finish the series by writing an end-of-stream record
+func (s *IntSeries) Finish() {
+	s.s.Finish()
+}
+
+// Push a timestamp in the unit of the series and a value.  Series created
+// with NewInt only keep the low 32 bits of t.
+func (s *IntSeries) Push(t, v int64) error {
+	return s.s.pushInt(t, v)
+}
+
+func (s *Series) pushInt(t, v int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	t, err := s.check(t)
+	if err != nil {
+		return err
+	}
+
+	if s.pushTime(t) {
+		s.bw.writeBits(uint64(v), 64)
+		s.ivDelta = 0
+	} else {
+		vDelta := v - s.ival
+		s.writeIntBucket(zigzag(vDelta - s.ivDelta))
+		s.ivDelta = vDelta
+	}
+	s.ival = v
+	s.val = float64(v)
+
+	s.pushed()
+	return nil
+}
+
+// Iter lets you iterate over the series.  It is not concurrency-safe.
+func (s *IntSeries) Iter() *IntIter {
+	return &IntIter{s.s.Iter()}
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (s *IntSeries) MarshalBinary() ([]byte, error) {
+	return s.s.MarshalBinary()
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.  The
+// series must have been created with the same timestamp width and unit as
+// the one that was marshaled.
+func (s *IntSeries) UnmarshalBinary(b []byte) error {
+	return s.s.UnmarshalBinary(b)
+}
+
+// IntIter lets you iterate over an integer series
+type IntIter struct {
+	it *Iter
+}
+
+// NewIntIterator for an integer series.  Blocks without a header must have
+// 32-bit timestamps.  Nor do they record their codec, so unlike a block with
+// a header, one written by a float series isn't rejected, and decodes as
+// meaningless integers.
+func NewIntIterator(b []byte) (*IntIter, error) {
+	return newIntIterator(b, format{codec: codecInt})
+}
+
+// NewIntIterator64 returns an iterator for a series created with NewInt64.
+// The unit is ignored if the block has a header.
+func NewIntIterator64(b []byte, unit Unit) (*IntIter, error) {
+	if unit > Nanosecond {
+		panic("tsz: invalid unit")
+	}
+	return newIntIterator(b, format{wide: true, unit: unit, codec: codecInt})
+}
+
+func newIntIterator(b []byte, f format) (*IntIter, error) {
+	f.framed = isFramed(b)
+	it, err := bstreamIterator(newBReader(b), f)
+	if err != nil {
+		return nil, err
+	}
+	if it.fmt.codec != codecInt {
+		return nil, ErrBadHeader
+	}
+	return &IntIter{it}, nil
+}
+
+// readInt decodes an integer value stored as a delta-of-delta
+func (it *Iter) readInt() error {
+	dod, err := it.readIntBucket()
+	if err != nil {
+		return err
+	}
+
+	it.ivDelta += dod
+	it.ival += it.ivDelta
+	it.val = float64(it.ival)
+	return nil
+}
+
+// readControl reads a '0', '10', '110', '1110' or '1111' bucket prefix,
+// returning the number of one bits
+func (it *Iter) readControl() (int, error) {
+	var d int
+	for ; d < 4; d++ {
+		bit, err := it.br.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == zero {
+			break
+		}
+	}
+	return d, nil
+}
+
+// Next iteration of the series iterator
+func (it *IntIter) Next() bool {
+	return it.it.Next()
+}
+
+// Values at the current iterator position, with the timestamp as returned by
+// Iter.Values64
+func (it *IntIter) Values() (int64, int64) {
+	t, _ := it.it.Values64()
+	return t, it.it.ival
+}
+
+// Seek64 advances the iterator to the first point with a timestamp of at
+// least t, as Iter.Seek64 does
+func (it *IntIter) Seek64(t int64) bool {
+	return it.it.Seek64(t)
+}
+
+// Start of the series being iterated
+func (it *IntIter) Start() int64 {
+	return it.it.Start()
+}
+
+// Err error at the current iterator position
+func (it *IntIter) Err() error {
+	return it.it.Err()
+}
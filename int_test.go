@@ -0,0 +1,171 @@
+package tsz
+
+import (
+	"math"
+	"testing"
+)
+
+func intValues() []int64 {
+	vals := []int64{0, 0, 1, -1, 1000, 1001, 1002, 1003, math.MaxInt64, math.MinInt64, 0, math.MinInt64, math.MaxInt64, -5}
+	for i := int64(0); i < 500; i++ {
+		vals = append(vals, i*i%997-400, 1<<40+i*3)
+	}
+	return vals
+}
+
+func TestIntRoundtrip(t *testing.T) {
+	vals := intValues()
+	for _, opts := range [][]Option{nil, {WithHeader()}, {WithIndex(7), WithChecksum()}} {
+		s := NewInt(1000, opts...)
+		for i, v := range vals {
+			if err := s.Push(int64(1000+i*60+i%3), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Finish()
+
+		it, err := NewIntIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		for it.Next() {
+			tt, vv := it.Values()
+			if tt != int64(1000+n*60+n%3) || vv != vals[n] {
+				t.Errorf("point %d=(%v,%v), want (%v,%v)", n, tt, vv, 1000+n*60+n%3, vals[n])
+			}
+			n++
+		}
+		if n != len(vals) || it.Err() != nil {
+			t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(vals))
+		}
+
+		if len(opts) != 0 {
+			if err := Verify(s.Bytes()); err != nil {
+				t.Errorf("Verify()=%v", err)
+			}
+		}
+	}
+}
+
+func TestIntFloatIterator(t *testing.T) {
+	s := NewInt(0, WithHeader())
+	for i := int64(0); i < 100; i++ {
+		s.Push(i*10, i*i-50)
+	}
+	s.Finish()
+
+	// a framed integer block decodes as floats too
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	for it.Next() {
+		if tt, vv := it.Values(); int64(tt) != n*10 || vv != float64(n*n-50) {
+			t.Errorf("point %d=(%v,%v), want (%v,%v)", n, tt, vv, n*10, n*n-50)
+		}
+		n++
+	}
+	if n != 100 || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want 100, nil", n, it.Err())
+	}
+
+	// and in batches
+	ts := make([]uint32, 100)
+	vs := make([]float64, 100)
+	if n, err := DecodeAll(s.Bytes(), ts, vs); n != 100 || err != nil || ts[99] != 990 || vs[99] != 99*99-50 {
+		t.Errorf("DecodeAll()=(%d,%v), last point (%v,%v); want (100,nil), (990,%v)", n, err, ts[99], vs[99], 99*99-50)
+	}
+
+	// but a float block isn't an integer one
+	f := New(0, WithHeader())
+	f.Push(0, 1)
+	f.Finish()
+	if _, err := NewIntIterator(f.Bytes()); err != ErrBadHeader {
+		t.Errorf("NewIntIterator(float block)=%v, want %v", err, ErrBadHeader)
+	}
+}
+
+func TestInt64Seek(t *testing.T) {
+	const n = 1000
+	s := NewInt64(1e18, Nanosecond, WithIndex(32))
+	for i := int64(0); i < n; i++ {
+		s.Push(1e18+i*1e9, i*7)
+	}
+
+	check := func(name string, it *IntIter) {
+		for _, i := range []int64{0, 31, 32, 33, 600, n - 1} {
+			if !it.Seek64(1e18 + i*1e9) {
+				t.Fatalf("%s: Seek64(point %d)=false, want true", name, i)
+			}
+			if tt, vv := it.Values(); tt != 1e18+i*1e9 || vv != i*7 {
+				t.Errorf("%s: Seek64(point %d) found (%v,%v)", name, i, tt, vv)
+			}
+		}
+	}
+
+	check("open", s.Iter())
+	s.Finish()
+	it, err := NewIntIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("finished", it)
+}
+
+func TestIntMarshalBinary(t *testing.T) {
+	vals := intValues()
+	s := NewInt(0)
+	for i, v := range vals[:100] {
+		s.Push(int64(i), v)
+	}
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := NewInt(0)
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range vals[100:] {
+		if err := s2.Push(int64(100+i), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s2.Finish()
+
+	it := s2.Iter()
+	var n int
+	for it.Next() {
+		if _, vv := it.Values(); vv != vals[n] {
+			t.Errorf("point %d=%v, want %v", n, vv, vals[n])
+		}
+		n++
+	}
+	if n != len(vals) {
+		t.Errorf("read %d points, want %d", n, len(vals))
+	}
+}
+
+func TestZigzag(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 2, -2, math.MaxInt64, math.MinInt64} {
+		if got := unzigzag(zigzag(v)); got != v {
+			t.Errorf("unzigzag(zigzag(%d))=%d", v, got)
+		}
+	}
+	if zigzag(-1) != 1 || zigzag(1) != 2 {
+		t.Errorf("zigzag(-1)=%d, zigzag(1)=%d; want 1, 2", zigzag(-1), zigzag(1))
+	}
+}
+
+func BenchmarkEncodeInt(b *testing.B) {
+	b.SetBytes(1440 * 16)
+	for i := 0; i < b.N; i++ {
+		s := NewInt(0)
+		for j := int64(0); j < 1440; j++ {
+			s.Push(j*60, j*1000+j%17)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package tsz
+
+import "math"
+
+// Series with integer runs encode values like the XOR codec, but with a
+// third bit after the '11' control bits of a value with a new window:
+//
+//	'0'                      same value
+//	'10'  + meaningful bits  reuse the window of the previous value
+//	'110' + window + bits    new window
+//	'111' + integer bucket   integer delta
+//
+// A value that is an integer following another integer may be stored as the
+// zigzag encoded difference of the two, in the buckets of an IntSeries,
+// whenever that takes fewer bits than its XOR.  The XOR window moves on as if
+// the XOR had been written, so XOR values cost the same as without integer
+// runs, but for the '0' on a new window.  Values that aren't exactly an int64,
+// including -0, NaNs and infinities, always take the XOR path, so every
+// float64 is decoded bit for bit.
+
+// WithIntegerRuns lets the float encoder store runs of integer values as
+// integer deltas, which for such runs takes far fewer bits than their XOR.
+// It implies WithHeader.  Like the other value codecs, it replaces any codec
+// chosen by an earlier option, and is ignored by integer series.
+func WithIntegerRuns() Option {
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.codec = codecXORInt
+	}
+}
+
+// asInt returns f as an int64, if it converts there and back exactly
+func asInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 || (f == 0 && math.Signbit(f)) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// pushIntRun stores v as an integer delta if it is an integer following
+// another, and that takes fewer bits than xorBits
+func (s *Series) pushIntRun(v float64, xorBits int) bool {
+	iv, ok := asInt(v)
+	if !ok {
+		return false
+	}
+	prev, ok := asInt(s.val)
+	if !ok {
+		return false
+	}
+
+	delta := zigzag(iv - prev)
+	if 3+intBucketBits(delta) >= xorBits {
+		return false
+	}
+
+	// after the '1' of a changed value
+	s.bw.writeBits(0x03, 2) // '11'
+	s.writeIntBucket(delta)
+	return true
+}
+
+// readIntRun decodes a value stored as an integer delta
+func (it *Iter) readIntRun() error {
+	prev, ok := asInt(it.val)
+	if !ok {
+		return ErrCorrupt
+	}
+
+	delta, err := it.readIntBucket()
+	if err != nil {
+		return err
+	}
+
+	v := float64(prev + delta)
+
+	// the window moves on as if the XOR had been written
+	vDelta := math.Float64bits(v) ^ math.Float64bits(it.val)
+	leading, trailing := it.fmt.xorWindow(vDelta)
+	if it.leading == ^uint8(0) || leading < it.leading || trailing < it.trailing {
+		it.leading, it.trailing = leading, trailing
+	}
+
+	it.val = v
+	return nil
+}
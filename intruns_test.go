@@ -0,0 +1,19 @@
+package tsz
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAsInt(t *testing.T) {
+	for _, f := range []float64{0.5, math.Copysign(0, -1), math.NaN(), math.Inf(1), math.Inf(-1), 1 << 63, -(1 << 64)} {
+		if _, ok := asInt(f); ok {
+			t.Errorf("asInt(%v) ok, want not", f)
+		}
+	}
+	for _, f := range []float64{0, -1, 1 << 62, -(1 << 63), 1<<63 - 1024} {
+		if i, ok := asInt(f); !ok || float64(i) != f {
+			t.Errorf("asInt(%v)=%d, %v", f, i, ok)
+		}
+	}
+}
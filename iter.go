@@ -0,0 +1,81 @@
+//go:build go1.23
+
+package tsz
+
+import "iter"
+
+// All returns an iterator over the remaining points of it.  It stops early if
+// decoding fails; check Err once the loop is done.
+func (it *Iter) All() iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		for it.Next() {
+			if !yield(it.Values()) {
+				return
+			}
+		}
+	}
+}
+
+// Range returns an iterator over the remaining points of it with timestamps
+// from start up to but not including end.  It seeks to start, using the skip
+// index if the block has one, and stops at the first point at or after end.
+// Like All, it stops early if decoding fails.
+func (it *Iter) Range(start, end uint32) iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		if !it.Seek(start) {
+			return
+		}
+		for {
+			t, v := it.Values()
+			if t >= end || !yield(t, v) {
+				return
+			}
+			if !it.Next() {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the points of the series, as of when the loop
+// starts.  Decoding errors end the loop as they do for Points; to check for
+// them, range over the All method of s.Iter() instead.
+func (s *Series) All() iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		s.Iter().All()(yield)
+	}
+}
+
+// Range returns an iterator over the points of the series with timestamps
+// from start up to but not including end.  Errors are handled as by All.
+func (s *Series) Range(start, end uint32) iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		s.Iter().Range(start, end)(yield)
+	}
+}
+
+// Points returns an iterator over the points of the block b.  Decoding errors
+// end the loop silently; to tell a damaged block from a complete one, range
+// over the All method of an Iter and check its Err afterwards, or call Verify.
+func Points(b []byte) iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		it, err := NewIterator(b)
+		if err != nil {
+			return
+		}
+		it.All()(yield)
+	}
+}
+
+// PointsRange returns an iterator over the points of the block b with
+// timestamps from start up to but not including end.  Errors are handled as
+// by Points.
+func PointsRange(b []byte, start, end uint32) iter.Seq2[uint32, float64] {
+	return func(yield func(uint32, float64) bool) {
+		it, err := NewIterator(b)
+		if err != nil {
+			return
+		}
+		it.Range(start, end)(yield)
+	}
+}
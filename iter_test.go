@@ -0,0 +1,148 @@
+//go:build go1.23
+
+package tsz
+
+import (
+	"io"
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+func TestPoints(t *testing.T) {
+	data := testdata.TwoHoursData
+	b := indexedBlock()
+
+	var n int
+	for tt, vv := range Points(b) {
+		if w := data[n]; tt != w.T || vv != w.V {
+			t.Errorf("point %d=(%v,%v), want (%v,%v)", n, tt, vv, w.T, w.V)
+		}
+		n++
+	}
+	if n != len(data) {
+		t.Errorf("ranged over %d points, want %d", n, len(data))
+	}
+
+	// breaking out of the loop
+	n = 0
+	for range Points(b) {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("ranged over %d points, want 3", n)
+	}
+
+	for range Points(b[:3]) {
+		t.Error("ranged over a point of a block without one")
+	}
+}
+
+func TestSeriesAll(t *testing.T) {
+	data := testdata.TwoHoursData
+	s := New(data[0].T)
+	for _, p := range data[:10] {
+		s.Push(p.T, p.V)
+	}
+
+	var n int
+	for tt, vv := range s.All() {
+		if w := data[n]; tt != w.T || vv != w.V {
+			t.Errorf("point %d=(%v,%v), want (%v,%v)", n, tt, vv, w.T, w.V)
+		}
+		n++
+	}
+	if n != 10 {
+		t.Errorf("ranged over %d points, want 10", n)
+	}
+
+	// the sequence sees points pushed after it was made
+	all := s.All()
+	s.Push(data[10].T, data[10].V)
+	n = 0
+	for range all {
+		n++
+	}
+	if n != 11 {
+		t.Errorf("ranged over %d points, want 11", n)
+	}
+}
+
+func TestRange(t *testing.T) {
+	data := testdata.TwoHoursData
+	start, end := data[20].T, data[30].T
+
+	check := func(name string, seq func(func(uint32, float64) bool)) {
+		n := 20
+		for tt, vv := range seq {
+			if w := data[n]; tt != w.T || vv != w.V {
+				t.Errorf("%s: point %d=(%v,%v), want (%v,%v)", name, n, tt, vv, w.T, w.V)
+			}
+			n++
+		}
+		if n != 30 {
+			t.Errorf("%s: stopped at point %d, want 30", name, n)
+		}
+	}
+
+	s := New(data[0].T, WithIndex(4))
+	for _, p := range data {
+		s.Push(p.T, p.V)
+	}
+	check("Series.Range", s.Range(start, end))
+	s.Finish()
+	check("PointsRange", PointsRange(s.Bytes(), start, end))
+	check("PointsRange(no index)", PointsRange(indexedBlock(), start, end))
+
+	for range PointsRange(s.Bytes(), data[len(data)-1].T+1, data[len(data)-1].T+100) {
+		t.Error("ranged over a point past the end")
+	}
+	for range PointsRange(s.Bytes(), end, start) {
+		t.Error("ranged over a point of an empty range")
+	}
+}
+
+func TestIterAllErr(t *testing.T) {
+	b := checksummedBlock()
+
+	// a truncated block ends the loop early
+	it, err := NewIterator(b[:len(b)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for range it.All() {
+		n++
+	}
+	if n == 0 || n >= len(testdata.TwoHoursData) || it.Err() != io.EOF {
+		t.Errorf("truncated block: ranged over %d points, err=%v; want some, %v", n, it.Err(), io.EOF)
+	}
+
+	// a bad checksum is found once every point is read
+	b[len(b)-1] ^= 1
+	it, err = NewIterator(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = 0
+	for range it.All() {
+		n++
+	}
+	if n != len(testdata.TwoHoursData) || it.Err() != ErrChecksum {
+		t.Errorf("bad checksum: ranged over %d points, err=%v; want %d, %v", n, it.Err(), len(testdata.TwoHoursData), ErrChecksum)
+	}
+
+	// and through Range
+	it, err = NewIterator(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range it.Range(testdata.TwoHoursData[10].T, 1<<32-1) {
+	}
+	if it.Err() != ErrChecksum {
+		t.Errorf("bad checksum: Range err=%v, want %v", it.Err(), ErrChecksum)
+	}
+}
@@ -0,0 +1,99 @@
+package tsz
+
+import "math"
+
+// A lossy series stores the previous value again if it is within the error
+// bound of a new one.  Otherwise it rounds the value to the fewest
+// significant bits that keep it within the bound before encoding it, so that
+// the XOR with the previous value ends in long runs of zeros.  The bound is
+// recorded in the header, after the point count:
+//
+//	bound    64 bits  float64
+//
+// with flagRelative set if it is a fraction of each value rather than an
+// absolute error.  NaNs, infinities and zeros are stored exactly.
+
+// WithAbsoluteError lets every value be stored up to bound away from the
+// pushed value, in exchange for a smaller block.  It implies WithHeader, and
+// is ignored by integer series.
+func WithAbsoluteError(bound float64) Option {
+	if !(bound > 0) || math.IsInf(bound, 1) {
+		panic("tsz: invalid error bound")
+	}
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.bound, f.relative = bound, false
+	}
+}
+
+// WithRelativeError lets every value be stored up to bound times its
+// magnitude away from the pushed value, so 0.001 keeps values within 0.1%.
+// It implies WithHeader, and is ignored by integer series.
+func WithRelativeError(bound float64) Option {
+	if !(bound > 0) || bound >= 1 {
+		panic("tsz: invalid error bound")
+	}
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.bound, f.relative = bound, true
+	}
+}
+
+// ErrorBound returns the error bound of a lossy series: every value it
+// decodes is within bound of the pushed value, or within bound times its
+// magnitude if relative is set.  It returns 0 if values are stored exactly.
+func (it *Iter) ErrorBound() (bound float64, relative bool) {
+	return it.fmt.bound, it.fmt.relative
+}
+
+// quantize returns the value to store for v within the error bound of the
+// series
+func (s *Series) quantize(v float64) float64 {
+	if s.fmt.bound == 0 || v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	if last, ok := s.last(); ok && math.Abs(last.V-v) <= errorBound(v, s.fmt) {
+		return last.V
+	}
+	return quantize(v, s.fmt)
+}
+
+// errorBound returns how far a value stored for v may be from it
+func errorBound(v float64, f format) float64 {
+	if f.relative {
+		return f.bound * math.Abs(v)
+	}
+	return f.bound
+}
+
+// quantize rounds v to the fewest significant bits that keep it within the
+// error bound of f
+func quantize(v float64, f format) float64 {
+	if f.bound == 0 || v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+
+	bound := errorBound(v, f)
+
+	// rounding away d bits moves v by at most half of 2^d of its ulps
+	_, exp := math.Frexp(v)
+	d := int(math.Floor(math.Log2(bound))) - (exp - 53) + 1
+	if d > 52 {
+		d = 52
+	}
+
+	u := math.Float64bits(v)
+	for ; d > 0; d-- {
+		q := math.Float64frombits((u + 1<<uint(d-1)) &^ (1<<uint(d) - 1))
+		if math.Abs(q-v) <= bound && !math.IsInf(q, 0) {
+			return q
+		}
+	}
+	return v
+}
@@ -0,0 +1,184 @@
+package tsz
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func lossyValues() []float64 {
+	vals := []float64{
+		0, math.Copysign(0, -1), 1, -1, math.Inf(1), math.Inf(-1), math.NaN(),
+		5e-324, -5e-324, 1e-310, math.MaxFloat64, -math.MaxFloat64,
+		math.Nextafter(1, 2), 0.1, 1e-9, 123456789.123, 1 << 60,
+	}
+
+	r := rand.New(rand.NewSource(4))
+	v := 100.0
+	for i := 0; i < 1000; i++ {
+		switch i / 250 {
+		case 0:
+			v += r.NormFloat64()
+			vals = append(vals, v)
+		case 1:
+			vals = append(vals, r.ExpFloat64()*math.Pow(10, float64(r.Intn(20)-10)))
+		case 2:
+			vals = append(vals, -r.Float64())
+		default:
+			vals = append(vals, math.Float64frombits(r.Uint64()))
+		}
+	}
+	return vals
+}
+
+func TestLossyBound(t *testing.T) {
+	vals := lossyValues()
+
+	for _, tt := range []struct {
+		bound    float64
+		relative bool
+		opts     []Option
+	}{
+		{1e-3, false, []Option{WithAbsoluteError(1e-3)}},
+		{0.5, false, []Option{WithAbsoluteError(0.5), WithChimp()}},
+		{100, false, []Option{WithAbsoluteError(100), WithIndex(10), WithChecksum()}},
+		{1e-6, true, []Option{WithRelativeError(1e-6)}},
+		{1e-3, true, []Option{WithRelativeError(1e-3), WithDecimal()}},
+		{0.1, true, []Option{WithRelativeError(0.1), WithChecksum()}},
+	} {
+		s := New(0, tt.opts...)
+		for i, v := range vals {
+			s.Push(uint32(i), v)
+		}
+		s.Finish()
+
+		if err := Verify(s.Bytes()); err != nil {
+			t.Fatalf("bound=%v: Verify()=%v", tt.bound, err)
+		}
+
+		it, err := NewIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, rel := it.ErrorBound(); b != tt.bound || rel != tt.relative {
+			t.Errorf("ErrorBound()=%v, %v; want %v, %v", b, rel, tt.bound, tt.relative)
+		}
+
+		var n int
+		for it.Next() {
+			_, v := it.Values()
+			want := vals[n]
+			bound := tt.bound
+			if tt.relative {
+				bound *= math.Abs(want)
+			}
+			switch {
+			case want == 0 || math.IsNaN(want) || math.IsInf(want, 0):
+				if math.Float64bits(v) != math.Float64bits(want) {
+					t.Errorf("bound=%v: point %d=%v, want exactly %v", tt.bound, n, v, want)
+				}
+			case !(math.Abs(v-want) <= bound):
+				t.Errorf("bound=%v relative=%v: point %d=%v, want within %v of %v", tt.bound, tt.relative, n, v, bound, want)
+			}
+			n++
+		}
+		if n != len(vals) || it.Err() != nil {
+			t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(vals))
+		}
+	}
+}
+
+func TestLossySmaller(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	exact, lossy := New(0), New(0, WithRelativeError(1e-3))
+	v := 1000.0
+	for i := 0; i < 1000; i++ {
+		v += r.NormFloat64()
+		exact.Push(uint32(i*60), v)
+		lossy.Push(uint32(i*60), v)
+	}
+
+	if e, l := len(exact.Bytes()), len(lossy.Bytes()); l >= e/2 {
+		t.Errorf("values within 0.1%% took %d bytes, %d exactly", l, e)
+	}
+}
+
+func TestLossyRepeat(t *testing.T) {
+	s := New(0, WithAbsoluteError(0.5))
+	for i, v := range []float64{10, 10.3, 9.8, 10.4, 11} {
+		s.Push(uint32(i), v)
+	}
+
+	var got []float64
+	for it := s.Iter(); it.Next(); {
+		_, v := it.Values()
+		got = append(got, v)
+	}
+
+	// values within the bound of the previous one repeat it
+	want := []float64{10, 10, 10, 10, 11}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values=%v, want %v", got, want)
+	}
+}
+
+func TestLossless(t *testing.T) {
+	s := New(0, WithHeader())
+	s.Push(1, math.Pi)
+	it := s.Iter()
+	if b, rel := it.ErrorBound(); b != 0 || rel {
+		t.Errorf("ErrorBound()=%v, %v for a lossless series", b, rel)
+	}
+	it.Next()
+	if _, v := it.Values(); v != math.Pi {
+		t.Errorf("value=%v, want %v", v, math.Pi)
+	}
+
+	// integer series ignore the bound
+	is := NewInt(0, WithAbsoluteError(10))
+	is.Push(1, 12345)
+	if f := is.s.fmt; f.bound != 0 {
+		t.Errorf("integer series has an error bound of %v", f.bound)
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	for _, tt := range []struct {
+		v, bound float64
+		relative bool
+		want     float64
+	}{
+		{math.Nextafter(1, 2), 1e-3, true, 1},
+		{1.999, 0.01, false, 2},
+		{-1.999, 0.01, false, -2},
+		{1234.5678, 1, false, 1234},
+		{1234.5678, 0.25, false, 1234.5},
+		{0.3, 0.5, false, 0.25},
+		{math.MaxFloat64, 0.5, true, math.MaxFloat64},
+	} {
+		f := format{bound: tt.bound, relative: tt.relative}
+		if got := quantize(tt.v, f); got != tt.want {
+			t.Errorf("quantize(%v, %v, %v)=%v, want %v", tt.v, tt.bound, tt.relative, got, tt.want)
+		}
+	}
+}
+
+func TestErrorBoundPanics(t *testing.T) {
+	for _, f := range []func(){
+		func() { WithAbsoluteError(0) },
+		func() { WithAbsoluteError(math.NaN()) },
+		func() { WithAbsoluteError(math.Inf(1)) },
+		func() { WithRelativeError(-1) },
+		func() { WithRelativeError(1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("invalid bound didn't panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
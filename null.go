@@ -0,0 +1,127 @@
+package tsz
+
+import (
+	"errors"
+	"math"
+)
+
+// A null point has a timestamp but no value, such as a scrape that failed.
+// Its value is stored in the control bits as a window no value is stored in,
+// with all the leading zeros the field holds and 63 meaningful bits, or for
+// Chimp as an XOR with no meaningful bits:
+//
+//	'11' + leading all ones + length 63          XOR
+//	'11' + '0' + leading all ones + length 63    XOR with integer runs
+//	'11' + '11' + leading all ones + length 63   decimal, as an exception
+//	'01' + code 0 + length 0                     Chimp
+//	'01' + position + code 0 + length 0          Chimp128
+//
+// The first value is stored in full, which leaves no room for a marker, so a
+// null first point is stored as 0 and flagged by flagFirstNull in the header.
+// Headerless blocks can't start with one.  Null points don't change the
+// value or the window the points after them are stored against.
+
+// ErrFirstNull is returned by PushNull for the first point of a series
+// without a header
+var ErrFirstNull = errors.New("tsz: null first point without a header")
+
+// nullLength is the length of the window of a null point
+const nullLength = 63
+
+// PushNull pushes a point with a timestamp but no value, which iterators
+// report with Null
+func (s *Series) PushNull(t uint32) error {
+	return s.PushNull64(int64(t))
+}
+
+// PushNull64 is PushNull with a timestamp in the unit of the series.  The
+// points held back by a filter or for lookahead are written first, and a
+// filter keeps the point after a null.
+func (s *Series) PushNull64(t int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	t, err := s.check(t)
+	if err != nil {
+		return err
+	}
+	if s.hold.held && t < s.hold.t {
+		return ErrOutOfOrder
+	}
+	if _, ok := s.last(); !ok && !s.fmt.framed {
+		return ErrFirstNull
+	}
+
+	if s.hold.held {
+		s.push(s.hold.t, s.hold.v)
+		s.hold.held = false
+	}
+	for len(s.pending) > 0 {
+		s.writePending()
+	}
+	s.writeNull(t)
+	return nil
+}
+
+// writeNull writes a null point that passed check
+func (s *Series) writeNull(t int64) {
+	if s.pushTime(t) {
+		s.bw.writeBits(math.Float64bits(s.val), 64)
+		s.fmt.firstNull = true
+		writeFlags(&s.bw, s.fmt)
+	} else {
+		switch s.fmt.codec {
+		case codecChimp, codecChimp128:
+			s.bw.writeBits(0x01, 2) // '01'
+			s.writeChimpRef((s.n - 2) % chimpRingSize)
+			s.bw.writeBits(0, 3+6)
+		case codecDecimal:
+			s.bw.writeBits(0x03, 2) // '11'
+			s.pushXORNull()
+		default:
+			s.pushXORNull()
+		}
+	}
+	s.null = true
+	if s.ring != nil {
+		s.ring.add(s.n-1, math.Float64bits(s.val))
+	}
+
+	s.pushed()
+}
+
+// pushXORNull writes the window of a null point
+func (s *Series) pushXORNull() {
+	s.bw.writeBits(0x03, 2) // '11'
+	if s.fmt.codec == codecXORInt {
+		s.bw.writeBit(zero)
+	}
+	s.bw.writeBits(1<<uint(s.fmt.leadingBits())-1, s.fmt.leadingBits())
+	s.bw.writeBits(nullLength, 6)
+}
+
+// isNullWindow reports whether a new window read from the stream is that of
+// a null point
+func (f format) isNullWindow(leading, length uint8) bool {
+	return int(leading) == 1<<uint(f.leadingBits())-1 && length == nullLength
+}
+
+// afterNull reports whether the last point pushed is null
+func (s *Series) afterNull() bool {
+	return s.null && len(s.pending) == 0
+}
+
+// Null reports whether the current point is null, pushed by PushNull.  Its
+// value is returned as a NaN, which Null tells apart from a NaN pushed as a
+// value.
+func (it *Iter) Null() bool {
+	return it.null
+}
+
+// value returns the value of the current point
+func (it *Iter) value() float64 {
+	if it.null {
+		return math.NaN()
+	}
+	return it.val
+}
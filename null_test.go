@@ -0,0 +1,216 @@
+package tsz
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// nullValue is a value pushed to a series, or a null point
+type nullValue struct {
+	v    float64
+	null bool
+}
+
+// withNulls is testValues with a NaN and a null among every few of them,
+// starting with a null
+func withNulls() []nullValue {
+	var vals []nullValue
+	for i, v := range testValues() {
+		switch i % 7 {
+		case 0:
+			vals = append(vals, nullValue{null: true})
+		case 3:
+			vals = append(vals, nullValue{v: math.NaN()})
+		}
+		vals = append(vals, nullValue{v: v})
+	}
+	return vals
+}
+
+// pushNulls pushes vals[from:to] to s, value i at i seconds
+func pushNulls(t *testing.T, s *Series, vals []nullValue, from, to int) {
+	for i := from; i < to; i++ {
+		var err error
+		if vals[i].null {
+			err = s.PushNull(uint32(i))
+		} else {
+			err = s.Push(uint32(i), vals[i].v)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkNulls checks that it reads back vals, as pushed by pushNulls
+func checkNulls(t *testing.T, it *Iter, vals []nullValue) {
+	var n int
+	for it.Next() {
+		ts, v := it.Values()
+		want := vals[n]
+		switch {
+		case ts != uint32(n):
+			t.Errorf("point %d at %d, want %d", n, ts, n)
+		case it.Null() != want.null:
+			t.Errorf("point %d: Null()=%v, want %v", n, it.Null(), want.null)
+		case want.null && !math.IsNaN(v):
+			t.Errorf("null point %d=%v, want NaN", n, v)
+		case !want.null && math.Float64bits(v) != math.Float64bits(want.v):
+			t.Errorf("point %d=%v, want %v", n, v, want.v)
+		}
+		n++
+	}
+	if n != len(vals) || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(vals))
+	}
+}
+
+func TestNullRoundtrip(t *testing.T) {
+	vals := withNulls()
+
+	for _, opts := range [][]Option{
+		{WithHeader()},
+		{WithWideLeading()},
+		{WithIntegerRuns()},
+		{WithDecimal()},
+		{WithChimp()},
+		{WithChimp128()},
+		{WithLookahead(4), WithIndex(5), WithChecksum()},
+		{WithAdaptiveBuckets(10)},
+	} {
+		s := New(0, opts...)
+		pushNulls(t, s, vals, 0, 100)
+
+		// an open series, without the points held back for lookahead
+		checkNulls(t, s.Iter(), vals[:100-len(s.pending)])
+
+		pushNulls(t, s, vals, 100, len(vals))
+		s.Finish()
+
+		if err := Verify(s.Bytes()); err != nil {
+			t.Fatalf("Verify()=%v", err)
+		}
+		it, err := NewIterator(s.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkNulls(t, it, vals)
+	}
+}
+
+func TestNullHeaderless(t *testing.T) {
+	s := New(0)
+	if err := s.PushNull(10); err != ErrFirstNull {
+		t.Errorf("PushNull(first point)=%v, want %v", err, ErrFirstNull)
+	}
+
+	// after the first point, nulls don't need a header
+	vals := withNulls()[1:]
+	pushNulls(t, s, vals, 0, len(vals))
+	s.Finish()
+
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNulls(t, it, vals)
+}
+
+func TestNullSmall(t *testing.T) {
+	s := New(0, WithHeader())
+	s.Push(0, 1.5)
+	for i := 1; i <= 1000; i++ {
+		s.PushNull(uint32(i * 60))
+	}
+	s.Finish()
+
+	// a 1-bit delta-of-delta and a 13-bit marker each
+	if n := len(s.Bytes()); n > 1000*14/8+64 {
+		t.Errorf("1000 null points took %d bytes", n)
+	}
+}
+
+func TestNullSeek(t *testing.T) {
+	vals := withNulls()
+	s := New(0, WithIndex(7))
+	pushNulls(t, s, vals, 0, len(vals))
+	s.Finish()
+
+	for _, i := range []int{0, 6, 7, 8, 49, 50, len(vals) - 1} {
+		it, _ := NewIterator(s.Bytes())
+		if !it.Seek(uint32(i)) {
+			t.Fatalf("Seek(point %d)=false, want true, err=%v", i, it.Err())
+		}
+		_, v := it.Values()
+		if it.Null() != vals[i].null || !vals[i].null && math.Float64bits(v) != math.Float64bits(vals[i].v) {
+			t.Errorf("Seek(point %d) found %v, null=%v; want %v, null=%v", i, v, it.Null(), vals[i].v, vals[i].null)
+		}
+	}
+}
+
+func TestNullFiltered(t *testing.T) {
+	s := New(0, WithDeadband(1))
+	for i, v := range []float64{10, 10.1, 10.2} {
+		s.Push(uint32(i), v)
+	}
+	if err := s.PushNull(1); err != ErrOutOfOrder {
+		t.Errorf("PushNull(earlier than the held point)=%v, want %v", err, ErrOutOfOrder)
+	}
+	// the held point is kept before the null, and the point after it is kept
+	s.PushNull(3)
+	for i, v := range []float64{10.3, 10.4, 10.5} {
+		s.Push(uint32(4+i), v)
+	}
+	s.Finish()
+
+	want := []nullValue{{v: 10}, {v: 10.2}, {null: true}, {v: 10.3}, {v: 10.5}}
+	it := s.Iter()
+	var n int
+	for it.Next() {
+		_, v := it.Values()
+		if n < len(want) && (it.Null() != want[n].null || !it.Null() && v != want[n].v) {
+			t.Errorf("point %d=%v, null=%v; want %v, null=%v", n, v, it.Null(), want[n].v, want[n].null)
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("read %d points, want %d", n, len(want))
+	}
+}
+
+func TestNullMarshalBinary(t *testing.T) {
+	vals := withNulls()
+	for _, cut := range []int{1, 7, 50} {
+		for _, opts := range [][]Option{{WithHeader()}, {WithChimp128()}, {WithDeadband(0.5)}} {
+			whole := New(0, opts...)
+			part := New(0, opts...)
+			pushNulls(t, whole, vals, 0, len(vals))
+			pushNulls(t, part, vals, 0, cut)
+			whole.Finish()
+
+			b, err := part.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := New(0, opts...)
+			if err := s.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+			pushNulls(t, s, vals, cut, len(vals))
+			s.Finish()
+
+			if !bytes.Equal(s.Bytes(), whole.Bytes()) {
+				t.Errorf("series unmarshaled after %d points differs from one never marshaled", cut)
+			}
+		}
+	}
+}
+
+func TestNullFirstHeader(t *testing.T) {
+	// integer series have no null points
+	f := format{codec: codecInt, firstNull: true}
+	if _, err := parseFlags(f.flags()); err != ErrBadHeader {
+		t.Errorf("integer codec with flagFirstNull: parseFlags()=%v, want %v", err, ErrBadHeader)
+	}
+}
@@ -9,103 +9,467 @@ package tsz
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
-	"math/bits"
 	"sync"
 )
 
+// Errors returned when a point is rejected by Push.  The series is left
+// unchanged.
+var (
+	ErrFinished           = errors.New("tsz: push to finished series")
+	ErrOutOfOrder         = errors.New("tsz: timestamp earlier than previous point")
+	ErrFirstDeltaOverflow = errors.New("tsz: first timestamp too far from T0") // without a header
+)
+
+// ErrCorrupt is returned by Iter.Err when a block fails a consistency check,
+// and by UnmarshalBinary when the marshaled state does
+var ErrCorrupt = errors.New("tsz: corrupt block")
+
+// Unit is the resolution of the timestamps stored in a series
+type Unit uint8
+
+// Supported timestamp units
+const (
+	Second Unit = iota
+	Millisecond
+	Microsecond
+	Nanosecond
+)
+
+// format describes how a series is laid out in the bit stream.  It is fixed
+// when the series is created and is either recorded in the block header or
+// must be supplied again when decoding.
+type format struct {
+	version uint8 // of the header, or 0 without one
+
+	framed   bool // block starts with a header
+	checksum bool // block ends with a CRC32 trailer
+	indexed  bool // block has a skip index after the end-of-stream record
+	interval int  // points between skip index entries, when encoding
+	wide     bool // 64-bit timestamps
+	unit     Unit
+	codec    valueCodec
+	bound    float64 // largest error of a lossy series, or 0
+	relative bool    // bound is a fraction of each value
+
+	filter    filterKind
+	tolerance float64
+
+	lookahead   int  // points held back to choose XOR windows, when encoding
+	reuse       bool // reuse every XOR window that fits, when encoding
+	wideLeading bool // leading zeros of XOR windows take 6 bits
+
+	buckets [3]uint8 // widths of the first three dod buckets, if not the unit's
+	learn   int      // points the buckets are learned from, if adaptive
+
+	firstNull bool // the first point is null
+}
+
+// tsEncoding holds the field widths used to encode timestamps
+type tsEncoding struct {
+	// width of the delta between T0 and the first point
+	first int
+	// width of each delta-of-delta bucket, selected by the '10', '110', '1110' and '1111' control codes
+	dod [4]int
+}
+
+// The 32-bit encoding is the one from the paper.  The 64-bit encodings scale
+// the buckets with the unit so that the same amount of jitter fits in each of
+// them, and let the first delta cover roughly 4.5 hours.  Blocks with a
+// header store longer first deltas in full.
+var (
+	tsEncoding32 = tsEncoding{first: 14, dod: [4]int{7, 9, 12, 32}}
+
+	tsEncoding64 = [...]tsEncoding{
+		Second:      {first: 14, dod: [4]int{7, 9, 12, 64}},
+		Millisecond: {first: 24, dod: [4]int{10, 14, 20, 64}},
+		Microsecond: {first: 34, dod: [4]int{14, 20, 27, 64}},
+		Nanosecond:  {first: 44, dod: [4]int{20, 27, 37, 64}},
+	}
+)
+
+func (f format) ts() tsEncoding {
+	ts := tsEncoding32
+	if f.wide {
+		ts = tsEncoding64[f.unit]
+	}
+	if f.buckets[0] != 0 {
+		for i, w := range f.buckets {
+			ts.dod[i] = int(w)
+		}
+	}
+	return ts
+}
+
+func (f format) t0Bits() int {
+	if f.wide {
+		return 64
+	}
+	return 32
+}
+
 // Series is the basic series primitive
 // you can concurrently put values, finish the stream, and create iterators
 type Series struct {
 	sync.Mutex
 
-	// TODO(dgryski): timestamps in the paper are uint64
+	// T0 is the start of a series created with New.  Use Start for series
+	// created with New64.
 	T0  uint32
-	t   uint32
+	t0  int64
+	t   int64
 	val float64
 
+	fmt      format
+	ts       tsEncoding // of fmt, resolved once
 	bw       bstream
 	leading  uint8
 	trailing uint8
 	finished bool
 
-	tDelta uint32
+	tDelta int64
+	n      int // number of points written
+	index  []indexEntry
+
+	// integer values, for IntSeries
+	ival, ivDelta int64
+
+	// recent values, for Chimp128
+	ring *chimpRing
+
+	// fractional digits, for decimal series
+	digits uint8
+
+	// whether the last point written is null, after the value of the one
+	// before it
+	null bool
+
+	// the point held back by a filter
+	hold heldPoint
+
+	// the points held back for lookahead, oldest first
+	pending []Point
+
+	// the number of delta-of-deltas of each width, until the buckets are
+	// learned from them
+	dods []int
+}
+
+// New series with 32-bit timestamps in seconds
+func New(t0 uint32, opts ...Option) *Series {
+	return newSeries(int64(t0), format{}, opts)
 }
 
-// New series
-func New(t0 uint32) *Series {
+// New64 creates a series with 64-bit timestamps in the given unit
+func New64(t0 int64, unit Unit, opts ...Option) *Series {
+	if unit > Nanosecond {
+		panic("tsz: invalid unit")
+	}
+	return newSeries(t0, format{wide: true, unit: unit}, opts)
+}
+
+func newSeries(t0 int64, f format, opts []Option) *Series {
+	for _, o := range opts {
+		o(&f)
+	}
+	if f.codec == codecChimp128 {
+		f.indexed = false
+	}
+	if !f.xorWindows() {
+		f.lookahead = 0
+		f.wideLeading = false
+	}
+	if f.framed {
+		f.version = version3
+	}
+
 	s := Series{
-		T0:      t0,
+		T0:      uint32(t0),
+		t0:      t0,
+		fmt:     f,
+		ts:      f.ts(),
 		leading: ^uint8(0),
 	}
+	if f.codec == codecChimp128 {
+		s.ring = &chimpRing{lookup: new(chimpLookup)}
+	}
+	if f.learn != 0 {
+		s.dods = make([]int, 65)
+	}
 
-	// block header
-	s.bw.writeBits(uint64(t0), 32)
+	start(&s.bw, f, t0)
 
 	return &s
 
 }
 
-// Bytes value of the series stream
+// start writes what a series holds before its first point: the block header,
+// if any, and T0
+func start(w *bstream, f format, t0 int64) {
+	if f.framed {
+		writeHeader(w, f)
+	}
+	w.writeBits(uint64(t0), f.t0Bits())
+}
+
+// Start of the series
+func (s *Series) Start() int64 {
+	return s.t0
+}
+
+// Bytes value of the series stream.  Until the series is finished, the bits
+// that don't fill a word yet are added to a copy of the stream, so each call
+// takes time and memory in proportion to the size of the block.
 func (s *Series) Bytes() []byte {
 	s.Lock()
 	defer s.Unlock()
 	return s.bw.bytes()
 }
 
-func finish(w *bstream) {
+func finish(w *bstream, f format) {
 	// write an end-of-stream record
 	w.writeBits(0x0f, 4)
-	w.writeBits(0xffffffff, 32)
+	if f.escaped() {
+		w.writeBit(one)
+		return
+	}
+	w.writeBits(^uint64(0), f.ts().dod[3])
 	w.writeBit(zero)
 }
 
+// wideFirst reports whether the first delta may be as wide as a timestamp
+func (f format) wideFirst() bool {
+	return f.version >= version3
+}
+
+// escaped reports whether the end of the stream is told from a point by an
+// escape bit, rather than by a delta-of-delta no point is stored with
+func (f format) escaped() bool {
+	return f.version >= version2
+}
+
+// seal ends the stream with an end-of-stream record followed by whatever
+// the format adds after it
+func seal(w *bstream, f format, n int, index []indexEntry) {
+	finish(w, f)
+	if f.framed {
+		writeCount(w, n)
+	}
+	if f.indexed {
+		writeIndex(w, f, index)
+	}
+	if f.checksum {
+		writeChecksum(w)
+	}
+	w.pad()
+	w.flushBytes()
+}
+
 // Finish the series by writing an end-of-stream record
 func (s *Series) Finish() {
 	s.Lock()
+	if s.hold.held {
+		s.push(s.hold.t, s.hold.v)
+		s.hold.held = false
+	}
+	for len(s.pending) > 0 {
+		s.writePending()
+	}
 	if !s.finished {
-		finish(&s.bw)
+		seal(&s.bw, s.fmt, s.n, s.index)
 		s.finished = true
 	}
 	s.Unlock()
 }
 
 // Push a timestamp and value to the series
-func (s *Series) Push(t uint32, v float64) {
+func (s *Series) Push(t uint32, v float64) error {
+	return s.Push64(int64(t), v)
+}
+
+// Push64 pushes a timestamp in the unit of the series and a value.  Series
+// created with New only keep the low 32 bits of t.
+func (s *Series) Push64(t int64, v float64) error {
 	s.Lock()
 	defer s.Unlock()
 
-	if s.t == 0 {
+	if s.fmt.filter != filterNone {
+		return s.filter(t, v)
+	}
+	return s.push(t, v)
+}
+
+// push writes a point, or holds it back for lookahead
+func (s *Series) push(t int64, v float64) error {
+	t, err := s.check(t)
+	if err != nil {
+		return err
+	}
+	if s.fmt.bound != 0 {
+		v = s.quantize(v)
+	}
+
+	if s.fmt.lookahead == 0 {
+		s.write(t, v)
+		return nil
+	}
+	s.pending = append(s.pending, Point{T: t, V: v})
+	if len(s.pending) > s.fmt.lookahead {
+		s.writePending()
+	}
+	return nil
+}
+
+// writePending writes the oldest point held back for lookahead, which the
+// rest of them follow
+func (s *Series) writePending() {
+	p := s.pending[0]
+	copy(s.pending, s.pending[1:])
+	s.pending = s.pending[:len(s.pending)-1]
+	s.write(p.T, p.V)
+}
+
+// last returns the last point pushed, written or held back for lookahead,
+// and whether there is one
+func (s *Series) last() (Point, bool) {
+	if n := len(s.pending); n > 0 {
+		return s.pending[n-1], true
+	}
+	return Point{T: s.t, V: s.val}, s.n != 0
+}
+
+// write writes a point that passed check
+func (s *Series) write(t int64, v float64) {
+	if s.pushTime(t) {
+		s.bw.writeBits(math.Float64bits(v), 64)
+		if s.fmt.codec == codecDecimal {
+			// start with the digits of the first value
+			s.digits, _ = decimalDigits(v, maxDigits)
+		}
+	} else {
+		switch s.fmt.codec {
+		case codecChimp, codecChimp128:
+			s.pushChimp(v)
+		case codecDecimal:
+			s.pushDecimal(v)
+		default:
+			s.pushXOR(v)
+		}
+	}
+	s.val = v
+	s.null = false
+	if s.ring != nil {
+		s.ring.add(s.n-1, math.Float64bits(v))
+	}
+
+	s.pushed()
+}
+
+// check returns the timestamp of a new point as it will be stored, or why the
+// point can't be pushed
+func (s *Series) check(t int64) (int64, error) {
+	if !s.fmt.wide {
+		t = int64(uint32(t))
+	}
+
+	last, ok := s.last()
+	switch {
+	case s.finished:
+		return 0, ErrFinished
+	case !ok && t < s.t0, ok && t < last.T:
+		return 0, ErrOutOfOrder
+	case !ok && !s.fmt.wideFirst() && uint64(t-s.t0) >= 1<<uint(s.ts.first):
+		return 0, ErrFirstDeltaOverflow
+	}
+
+	return t, nil
+}
+
+// pushed records a point once its value has been written
+func (s *Series) pushed() {
+	if s.n == s.fmt.learn || s.fmt.indexed {
+		s.record()
+	}
+}
+
+// record writes the buckets once they are learned, and indexes the point
+func (s *Series) record() {
+	if s.n == s.fmt.learn {
+		s.writeBuckets()
+	}
+	if s.fmt.indexed && s.n%s.fmt.interval == 0 {
+		s.index = append(s.index, s.indexEntry())
+	}
+}
+
+// pushTime encodes the timestamp of a point checked by check, reporting
+// whether it is the first point, whose value is written in full
+func (s *Series) pushTime(t int64) bool {
+	ts := &s.ts
+
+	s.n++
+
+	if s.n == 1 {
 		// first point
 		s.t = t
-		s.val = v
-		s.tDelta = t - s.T0
-		s.bw.writeBits(uint64(s.tDelta), 14)
-		s.bw.writeBits(math.Float64bits(v), 64)
-		return
+		s.tDelta = t - s.t0
+		s.writeFirst(uint64(s.tDelta))
+		return true
 	}
 
 	tDelta := t - s.t
-	dod := int32(tDelta - s.tDelta)
+	dod := tDelta - s.tDelta
+	if !s.fmt.wide {
+		dod = int64(int32(dod))
+	}
+	s.countDod(dod)
 
 	switch {
 	case dod == 0:
 		s.bw.writeBit(zero)
-	case -63 <= dod && dod <= 64:
+	case fitsBucket(dod, ts.dod[0]):
 		s.bw.writeBits(0x02, 2) // '10'
-		s.bw.writeBits(uint64(dod), 7)
-	case -255 <= dod && dod <= 256:
+		s.bw.writeBits(uint64(dod), ts.dod[0])
+	case fitsBucket(dod, ts.dod[1]):
 		s.bw.writeBits(0x06, 3) // '110'
-		s.bw.writeBits(uint64(dod), 9)
-	case -2047 <= dod && dod <= 2048:
+		s.bw.writeBits(uint64(dod), ts.dod[1])
+	case fitsBucket(dod, ts.dod[2]):
 		s.bw.writeBits(0x0e, 4) // '1110'
-		s.bw.writeBits(uint64(dod), 12)
+		s.bw.writeBits(uint64(dod), ts.dod[2])
 	default:
 		s.bw.writeBits(0x0f, 4) // '1111'
-		s.bw.writeBits(uint64(dod), 32)
+		if s.fmt.escaped() {
+			s.bw.writeBit(zero)
+		}
+		s.bw.writeBits(uint64(dod), ts.dod[3])
 	}
 
+	s.tDelta = tDelta
+	s.t = t
+	return false
+}
+
+// writeFirst writes the delta between T0 and the first point
+func (s *Series) writeFirst(delta uint64) {
+	first := s.ts.first
+	if !s.fmt.wideFirst() {
+		s.bw.writeBits(delta, first)
+		return
+	}
+
+	if delta < 1<<uint(first) {
+		s.bw.writeBit(zero)
+		s.bw.writeBits(delta, first)
+		return
+	}
+	s.bw.writeBit(one)
+	s.bw.writeBits(delta, s.fmt.t0Bits())
+}
+
+// pushXOR encodes a value as its XOR with the previous one
+func (s *Series) pushXOR(v float64) {
 	vDelta := math.Float64bits(v) ^ math.Float64bits(s.val)
 
 	if vDelta == 0 {
@@ -113,23 +477,38 @@ func (s *Series) Push(t uint32, v float64) {
 	} else {
 		s.bw.writeBit(one)
 
-		leading := uint8(bits.LeadingZeros64(vDelta))
-		trailing := uint8(bits.TrailingZeros64(vDelta))
+		leading, trailing := s.fmt.xorWindow(vDelta)
+		reuse := !s.resetWindow(v, leading, trailing)
 
-		// clamp number of leading zeros to avoid overflow when encoding
-		if leading >= 32 {
-			leading = 31
+		if s.fmt.codec == codecXORInt {
+			xorBits := 2 + 64 - int(s.leading) - int(s.trailing)
+			if !reuse {
+				xorBits = 3 + s.fmt.windowBits() + 64 - int(leading) - int(trailing)
+			}
+			// An integer run keeps a window the value fits in, so it doesn't
+			// replace a new window chosen by cost, which would leave the
+			// window wider than without integer runs.
+			if (reuse || !s.fits(leading, trailing)) && s.pushIntRun(v, xorBits) {
+				// the window moves on as if the XOR had been written, which
+				// the decoder only knows to do when it doesn't fit
+				if !s.fits(leading, trailing) {
+					s.leading, s.trailing = leading, trailing
+				}
+				return
+			}
 		}
 
-		// TODO(dgryski): check if it's 'cheaper' to reset the leading/trailing bits instead
-		if s.leading != ^uint8(0) && leading >= s.leading && trailing >= s.trailing {
+		if reuse {
 			s.bw.writeBit(zero)
 			s.bw.writeBits(vDelta>>s.trailing, 64-int(s.leading)-int(s.trailing))
 		} else {
 			s.leading, s.trailing = leading, trailing
 
 			s.bw.writeBit(one)
-			s.bw.writeBits(uint64(leading), 5)
+			if s.fmt.codec == codecXORInt {
+				s.bw.writeBit(zero)
+			}
+			s.bw.writeBits(uint64(leading), s.fmt.leadingBits())
 
 			// Note that if leading == trailing == 0, then sigbits == 64.  But that value doesn't actually fit into the 6 bits we have.
 			// Luckily, we never need to encode 0 significant bits, since that would put us in the other case (vdelta == 0).
@@ -139,59 +518,147 @@ func (s *Series) Push(t uint32, v float64) {
 			s.bw.writeBits(vDelta>>trailing, int(sigbits))
 		}
 	}
+}
 
-	s.tDelta = tDelta
-	s.t = t
-	s.val = v
-
+// fitsBucket reports whether dod can be stored in a delta-of-delta bucket of
+// the given width.  The range is skewed by one towards the positive side.
+func fitsBucket(dod int64, width int) bool {
+	return -(1<<uint(width-1))+1 <= dod && dod <= 1<<uint(width-1)
 }
 
 // Iter lets you iterate over a series.  It is not concurrency-safe.
+//
+// The iterator of an open series is a snapshot of the points pushed so far.
+// It reads the bytes the series has already written in place, since they
+// aren't changed by later pushes, and only copies the few bits still waiting
+// to be written.  The series may keep growing while it is read.
 func (s *Series) Iter() *Iter {
 	s.Lock()
-	w := s.bw.clone()
-	s.Unlock()
+	defer s.Unlock()
+
+	if s.finished {
+		// nothing writes to a finished stream
+		iter, _ := bstreamIterator(newBReader(s.bw.bytes()), s.fmt)
+		return iter
+	}
+
+	// end the pending bits with an end-of-stream record in a copy of their
+	// own, with room for up to 63 pending bits, a 69-bit record and padding
+	tail := bstream{stream: make([]byte, 0, 24), acc: s.bw.acc, count: s.bw.count}
+	finish(&tail, s.fmt)
+	tail.pad()
+	tail.flushBytes()
+
+	// The header is read under the lock, as Finish fills in its point count.
+	// Reading the rest races with nothing: later pushes only append.
+	br := breader{stream: s.bw.stream, tail: tail.stream}
+	iter, _ := bstreamIterator(&br, s.fmt)
+
+	// the trailer isn't written yet, so use what it will hold
+	iter.fmt.checksum = false
+	iter.fmt.indexed = false
+	iter.count = s.n
+	iter.index, iter.interval, iter.indexLoaded = s.index, s.fmt.interval, true
 
-	finish(w)
-	iter, _ := bstreamIterator(w)
 	return iter
 }
 
 // Iter lets you iterate over a series.  It is not concurrency-safe.
 type Iter struct {
+	// T0 is the start of a 32-bit series.  Use Start for 64-bit series.
 	T0 uint32
+	t0 int64
 
-	t   uint32
+	t   int64
 	val float64
 
-	br       bstream
+	fmt      format
+	ts       tsEncoding // of fmt, resolved once
+	br       breader
 	leading  uint8
 	trailing uint8
 
 	finished bool
 
-	tDelta uint32
+	tDelta int64
+	n      int // number of points read
+	count  int // number of points in the header, or -1 if unknown
 	err    error
+
+	// integer values, for IntSeries
+	ival, ivDelta int64
+
+	// recent values, for Chimp128
+	ring *chimpRing
+
+	// fractional digits, for decimal series
+	digits uint8
+
+	// whether the current point is null, after the value of the one before
+	// it
+	null bool
+
+	// skip index, loaded by the first Seek
+	index       []indexEntry
+	interval    int
+	indexLoaded bool
 }
 
-func bstreamIterator(br *bstream) (*Iter, error) {
+func bstreamIterator(br *breader, f format) (*Iter, error) {
 
-	br.count = 8
+	count := -1
+	if f.framed {
+		var err error
+		f, count, err = readHeader(br)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	t0, err := br.readBits(32)
+	t0, err := br.readBits(f.t0Bits())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Iter{
-		T0: uint32(t0),
-		br: *br,
-	}, nil
+	it := &Iter{
+		T0:      uint32(t0),
+		t0:      int64(t0),
+		fmt:     f,
+		ts:      f.ts(),
+		br:      *br,
+		count:   count,
+		leading: ^uint8(0),
+	}
+	if f.codec == codecChimp128 {
+		it.ring = new(chimpRing)
+	}
+	return it, nil
 }
 
-// NewIterator for the series
+// NewIterator for the series.  Blocks with a header are decoded in whatever
+// format they record; blocks without one must have 32-bit timestamps.  The
+// bytes are only read, so several iterators may share them.
 func NewIterator(b []byte) (*Iter, error) {
-	return bstreamIterator(newBReader(b))
+	return bstreamIterator(newBReader(b), format{framed: isFramed(b)})
+}
+
+// NewIterator64 returns an iterator for a series created with New64.  The
+// unit is ignored if the block has a header.
+func NewIterator64(b []byte, unit Unit) (*Iter, error) {
+	if unit > Nanosecond {
+		panic("tsz: invalid unit")
+	}
+	return bstreamIterator(newBReader(b), format{framed: isFramed(b), wide: true, unit: unit})
+}
+
+// Start of the series being iterated
+func (it *Iter) Start() int64 {
+	return it.t0
+}
+
+// Unit of the timestamps being iterated
+func (it *Iter) Unit() Unit {
+	return it.fmt.unit
 }
 
 // Next iteration of the series iterator
@@ -201,24 +668,41 @@ func (it *Iter) Next() bool {
 		return false
 	}
 
-	if it.t == 0 {
+	ts := &it.ts
+
+	// a block known to be empty goes on to its end-of-stream record, which
+	// would otherwise be read as a first point
+	if it.n == 0 && it.count != 0 {
 		// read first t and v
-		tDelta, err := it.br.readBits(14)
+		tDelta, err := it.readFirst()
 		if err != nil {
 			it.err = err
 			return false
 		}
-		it.tDelta = uint32(tDelta)
-		it.t = it.T0 + it.tDelta
+		it.tDelta = int64(tDelta)
+		it.t = it.t0 + it.tDelta
 		v, err := it.br.readBits(64)
 		if err != nil {
 			it.err = err
 			return false
 		}
 
-		it.val = math.Float64frombits(v)
+		if it.fmt.codec == codecInt {
+			it.ival = int64(v)
+			it.val = float64(it.ival)
+		} else {
+			it.val = math.Float64frombits(v)
+		}
+		it.null = it.fmt.firstNull
+		if it.fmt.codec == codecDecimal {
+			it.digits, _ = decimalDigits(it.val, maxDigits)
+		}
+		if it.ring != nil {
+			it.ring.add(it.n, v)
+		}
+		it.n++
 
-		return true
+		return it.learned()
 	}
 
 	// read delta-of-delta
@@ -236,31 +720,53 @@ func (it *Iter) Next() bool {
 		d |= 1
 	}
 
-	var dod int32
+	var dod int64
 	var sz uint
 	switch d {
 	case 0x00:
 		// dod == 0
 	case 0x02:
-		sz = 7
+		sz = uint(ts.dod[0])
 	case 0x06:
-		sz = 9
+		sz = uint(ts.dod[1])
 	case 0x0e:
-		sz = 12
+		sz = uint(ts.dod[2])
 	case 0x0f:
-		bits, err := it.br.readBits(32)
-		if err != nil {
-			it.err = err
-			return false
+		var end bool
+		if it.fmt.escaped() {
+			bit, err := it.br.readBit()
+			if err != nil {
+				it.err = err
+				return false
+			}
+			end = bit == one
+		}
+
+		sz = uint(ts.dod[3])
+		var bits uint64
+		if !end {
+			var err error
+			bits, err = it.br.readBits(int(sz))
+			if err != nil {
+				it.err = err
+				return false
+			}
+			end = !it.fmt.escaped() && bits == ^uint64(0)>>(64-sz)
 		}
 
 		// end of stream
-		if bits == 0xffffffff {
+		if end {
 			it.finished = true
+			it.err = it.readTrailer()
+			if it.err == nil && it.count >= 0 && it.n != it.count {
+				it.err = ErrCorrupt
+			}
 			return false
 		}
 
-		dod = int32(bits)
+		// sign extend
+		dod = int64(bits<<(64-sz)) >> (64 - sz)
+		sz = 0
 	}
 
 	if sz != 0 {
@@ -273,70 +779,157 @@ func (it *Iter) Next() bool {
 			// or something
 			bits = bits - (1 << sz)
 		}
-		dod = int32(bits)
+		dod = int64(bits)
 	}
 
-	tDelta := it.tDelta + uint32(dod)
+	tDelta := it.tDelta + dod
 
 	it.tDelta = tDelta
 	it.t = it.t + it.tDelta
+	if !it.fmt.wide {
+		// the delta-of-delta wrapped around in 32 bits, like the timestamps
+		it.tDelta = int64(uint32(it.tDelta))
+		it.t = int64(uint32(it.t))
+	}
 
 	// read compressed value
-	bit, err := it.br.readBit()
+	it.null = false
+	var err error
+	switch it.fmt.codec {
+	case codecInt:
+		err = it.readInt()
+	case codecChimp, codecChimp128:
+		err = it.readChimp()
+	case codecDecimal:
+		err = it.readDecimal()
+	default:
+		err = it.readXOR()
+	}
 	if err != nil {
 		it.err = err
 		return false
 	}
+	if it.ring != nil {
+		it.ring.add(it.n, math.Float64bits(it.val))
+	}
+
+	it.n++
+
+	return it.learned()
+}
+
+// learned reads the buckets learned from the points read so far, if they
+// follow them, and reports whether the current point can be used
+func (it *Iter) learned() bool {
+	if it.n == it.fmt.learn {
+		it.err = it.readBuckets()
+	}
+	return it.err == nil
+}
+
+// readFirst reads the delta between T0 and the first point
+func (it *Iter) readFirst() (uint64, error) {
+	width := it.ts.first
+	if it.fmt.wideFirst() {
+		bit, err := it.br.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == one {
+			width = it.fmt.t0Bits()
+		}
+	}
+	return it.br.readBits(width)
+}
+
+// readXOR decodes a value stored as its XOR with the previous one
+func (it *Iter) readXOR() error {
+	bit, err := it.br.readBit()
+	if err != nil {
+		return err
+	}
 
 	if bit == zero {
 		// it.val = it.val
-	} else {
-		bit, itErr := it.br.readBit()
-		if itErr != nil {
-			it.err = err
-			return false
-		}
-		if bit == zero {
-			// reuse leading/trailing zero bits
-			// it.leading, it.trailing = it.leading, it.trailing
-		} else {
-			bits, err := it.br.readBits(5)
-			if err != nil {
-				it.err = err
-				return false
-			}
-			it.leading = uint8(bits)
+		return nil
+	}
+	return it.readXORChanged()
+}
 
-			bits, err = it.br.readBits(6)
+// readXORChanged decodes the XOR of a value that changed, after its '1'
+func (it *Iter) readXORChanged() error {
+	bit, err := it.br.readBit()
+	if err != nil {
+		return err
+	}
+	if bit == zero {
+		// reuse leading/trailing zero bits
+		// it.leading, it.trailing = it.leading, it.trailing
+		if it.leading == ^uint8(0) {
+			// there's nothing to reuse yet
+			return ErrCorrupt
+		}
+	} else {
+		if it.fmt.codec == codecXORInt {
+			bit, err := it.br.readBit()
 			if err != nil {
-				it.err = err
-				return false
+				return err
 			}
-			mbits := uint8(bits)
-			// 0 significant bits here means we overflowed and we actually need 64; see comment in encoder
-			if mbits == 0 {
-				mbits = 64
+			if bit == one {
+				return it.readIntRun()
 			}
-			it.trailing = 64 - it.leading - mbits
 		}
 
-		mbits := int(64 - it.leading - it.trailing)
-		bits, err := it.br.readBits(mbits)
+		bits, err := it.br.readBits(it.fmt.leadingBits())
 		if err != nil {
-			it.err = err
-			return false
+			return err
+		}
+		leading := uint8(bits)
+
+		bits, err = it.br.readBits(6)
+		if err != nil {
+			return err
 		}
-		vbits := math.Float64bits(it.val)
-		vbits ^= (bits << it.trailing)
-		it.val = math.Float64frombits(vbits)
+		mbits := uint8(bits)
+		if it.fmt.isNullWindow(leading, mbits) {
+			it.null = true
+			return nil
+		}
+		it.leading = leading
+		// 0 significant bits here means we overflowed and we actually need 64; see comment in encoder
+		if mbits == 0 {
+			mbits = 64
+		}
+		if int(it.leading)+int(mbits) > 64 {
+			return ErrCorrupt
+		}
+		it.trailing = 64 - it.leading - mbits
 	}
 
-	return true
+	mbits := int(64 - it.leading - it.trailing)
+	bits, err := it.br.readBits(mbits)
+	if err != nil {
+		return err
+	}
+	vbits := math.Float64bits(it.val)
+	vbits ^= (bits << it.trailing)
+	it.val = math.Float64frombits(vbits)
+
+	return nil
 }
 
 // Values at the current iterator position
 func (it *Iter) Values() (uint32, float64) {
-	return it.t, it.val
+	return uint32(it.t), it.value()
+}
+
+// Values64 returns the values at the current iterator position with the full
+// 64-bit timestamp
+func (it *Iter) Values64() (int64, float64) {
+	if !it.fmt.wide {
+		return int64(uint32(it.t)), it.value()
+	}
+	return it.t, it.value()
 }
 
 // Err error at the current iterator position
@@ -364,16 +957,63 @@ func (em *errMarshal) read(t interface{}) {
 	em.err = binary.Read(em.r, binary.BigEndian, t)
 }
 
+// writeTime writes a timestamp field, truncated to 32 bits unless wide is set
+func (em *errMarshal) writeTime(t int64, wide bool) {
+	if wide {
+		em.write(t)
+		return
+	}
+	em.write(uint32(t))
+}
+
+func (em *errMarshal) readTime(t *int64, wide bool) {
+	if wide {
+		em.read(t)
+		return
+	}
+	var u uint32
+	em.read(&u)
+	*t = int64(u)
+}
+
+// stateVersion starts the marshaled state of a series with a filter or
+// lookahead, which holds the points they hold back.  The state of any other
+// series has no version, and is laid out as in releases before either
+// existed, so that it can still be read.
+const stateVersion uint8 = 1
+
+// heldState reports whether the series holds points back from the stream
+func (f format) heldState() bool {
+	return f.filter != filterNone || f.lookahead != 0
+}
+
 // MarshalBinary implements the encoding.BinaryMarshaler interface
 func (s *Series) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	em := &errMarshal{w: buf}
-	em.write(s.T0)
+	if s.fmt.heldState() {
+		em.write(stateVersion)
+	}
+	em.writeTime(s.t0, s.fmt.wide)
 	em.write(s.leading)
-	em.write(s.t)
-	em.write(s.tDelta)
+	em.writeTime(s.t, s.fmt.wide)
+	em.writeTime(s.tDelta, s.fmt.wide)
 	em.write(s.trailing)
 	em.write(s.val)
+	if s.fmt.filter != filterNone {
+		em.write(s.hold.held)
+		em.writeTime(s.hold.t, s.fmt.wide)
+		em.write(s.hold.v)
+		em.write(s.hold.lo)
+		em.write(s.hold.hi)
+	}
+	if s.fmt.lookahead != 0 {
+		em.write(uint32(len(s.pending)))
+		for _, p := range s.pending {
+			em.writeTime(p.T, s.fmt.wide)
+			em.write(p.V)
+		}
+	}
 	bStream, err := s.bw.MarshalBinary()
 	if err != nil {
 		return nil, err
@@ -385,16 +1025,45 @@ func (s *Series) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.  The
+// series must have been created with the same timestamp width and unit as
+// the one that was marshaled.
 func (s *Series) UnmarshalBinary(b []byte) error {
 	buf := bytes.NewReader(b)
 	em := &errMarshal{r: buf}
-	em.read(&s.T0)
+	if s.fmt.heldState() {
+		var v uint8
+		em.read(&v)
+		if em.err == nil && v != stateVersion {
+			return ErrCorrupt
+		}
+	}
+	em.readTime(&s.t0, s.fmt.wide)
+	s.T0 = uint32(s.t0)
 	em.read(&s.leading)
-	em.read(&s.t)
-	em.read(&s.tDelta)
+	em.readTime(&s.t, s.fmt.wide)
+	em.readTime(&s.tDelta, s.fmt.wide)
 	em.read(&s.trailing)
 	em.read(&s.val)
+	if s.fmt.filter != filterNone {
+		em.read(&s.hold.held)
+		em.readTime(&s.hold.t, s.fmt.wide)
+		em.read(&s.hold.v)
+		em.read(&s.hold.lo)
+		em.read(&s.hold.hi)
+	}
+	if s.fmt.lookahead != 0 {
+		var n uint32
+		em.read(&n)
+		if em.err == nil && int(n) > s.fmt.lookahead {
+			return ErrCorrupt
+		}
+		s.pending = make([]Point, n, s.fmt.lookahead+1)
+		for i := range s.pending {
+			em.readTime(&s.pending[i].T, s.fmt.wide)
+			em.read(&s.pending[i].V)
+		}
+	}
 	outBuf := make([]byte, buf.Len())
 	em.read(outBuf)
 	err := s.bw.UnmarshalBinary(outBuf)
@@ -404,5 +1073,72 @@ func (s *Series) UnmarshalBinary(b []byte) error {
 	if em.err != nil {
 		return em.err
 	}
-	return nil
+
+	// the point count and whether the series was finished aren't part of the
+	// marshaled state; recover them from the stream
+	written := len(s.bw.stream)*8 + int(s.bw.count)
+	w := s.bw.clone()
+	finish(w, s.fmt)
+	it, err := bstreamIterator(newBReader(w.bytes()), s.fmt)
+	if err != nil {
+		return err
+	}
+	// there's no trailer after the record we just wrote
+	it.fmt.checksum = false
+	it.fmt.indexed = false
+	if s.empty() {
+		// go on to the end-of-stream record, which would otherwise be read
+		// as a first point
+		it.count = 0
+	}
+	s.index = nil
+	if s.fmt.learn != 0 {
+		s.dods = make([]int, 65)
+	}
+	for tDelta := it.tDelta; it.Next(); tDelta = it.tDelta {
+		if it.n > 1 && it.n <= s.fmt.learn {
+			dod := it.tDelta - tDelta
+			if !s.fmt.wide {
+				dod = int64(int32(dod))
+			}
+			s.countDod(dod)
+		}
+		if s.fmt.indexed && it.n%s.fmt.interval == 0 {
+			s.index = append(s.index, it.indexEntry())
+		}
+	}
+	s.n = it.n
+	if s.fmt.learn != 0 {
+		s.fmt.buckets = it.fmt.buckets
+		s.ts = s.fmt.ts()
+		if s.n >= s.fmt.learn {
+			s.dods = nil
+		}
+	}
+	s.ival, s.ivDelta = it.ival, it.ivDelta
+	s.digits = it.digits
+	s.null = it.null
+	s.fmt.firstNull = it.fmt.firstNull
+	if s.ring != nil {
+		// refill the table from the oldest value still in the ring
+		for i := s.n - chimpRingSize; i < s.n; i++ {
+			if i >= 0 {
+				s.ring.add(i, it.ring.vals[i%chimpRingSize])
+			}
+		}
+	}
+	s.finished = it.br.offset() <= written
+	return it.Err()
+}
+
+// empty reports whether the stream of s holds no points: nothing after T0
+// but, once finished, what seal writes
+func (s *Series) empty() bool {
+	var w bstream
+	start(&w, s.fmt, s.t0)
+	if bytes.Equal(w.bytes(), s.bw.bytes()) {
+		return true
+	}
+	seal(&w, s.fmt, 0, nil)
+	return bytes.Equal(w.bytes(), s.bw.bytes())
 }
@@ -1,6 +1,10 @@
 package tsz
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -36,6 +40,107 @@ func TestMarshalBinary(t *testing.T) {
 	}
 }
 
+func TestUnmarshalBinaryOldLayout(t *testing.T) {
+	data := testdata.TwoHoursData
+	for _, tt := range []struct {
+		n int
+		b []byte
+	}{
+		// marshaled by earlier releases, with the first n points
+		{5, []byte{
+			0x55, 0xdd, 0x8e, 0x20, 0x0e, 0x55, 0xdd, 0x8f, 0x10, 0x00, 0x00, 0x00, 0x3c, 0x2c,
+			0x40, 0x85, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 0x55, 0xdd, 0x8e, 0x20, 0x00,
+			0x01, 0x02, 0x1f, 0x20, 0x00, 0x00, 0x00, 0x00, 0x02, 0x79, 0xbc, 0x5b, 0xaa, 0xb7,
+			0x8d, 0xfb, 0x70, 0xdf, 0x80,
+		}},
+		{0, []byte{
+			0x55, 0xdd, 0x8e, 0x20, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x08, 0x55, 0xdd, 0x8e, 0x20, 0x00,
+		}},
+	} {
+		s := New(data[0].T)
+		if err := s.UnmarshalBinary(tt.b); err != nil {
+			t.Fatalf("%d points: UnmarshalBinary()=%v", tt.n, err)
+		}
+		for _, p := range data[tt.n:] {
+			s.Push(p.T, p.V)
+		}
+		s.Finish()
+
+		it := s.Iter()
+		var n int
+		for ; it.Next(); n++ {
+			if ts, v := it.Values(); ts != data[n].T || v != data[n].V {
+				t.Errorf("point %d=(%v,%v), want (%v,%v)", n, ts, v, data[n].T, data[n].V)
+			}
+		}
+		if n != len(data) || it.Err() != nil {
+			t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(data))
+		}
+	}
+}
+
+func TestMarshalBinaryEmpty(t *testing.T) {
+	data := testdata.TwoHoursData
+	for _, tt := range []struct {
+		opts []Option
+		held int // points pushed but not written yet
+	}{
+		{nil, 0},
+		{[]Option{WithHeader()}, 0},
+		{[]Option{WithIndex(4), WithChecksum()}, 0},
+		{[]Option{WithChimp128()}, 0},
+		{[]Option{WithDecimal()}, 0},
+		{[]Option{WithDeadband(10)}, 0},
+		{[]Option{WithAdaptiveBuckets(5)}, 0},
+		{[]Option{WithLookahead(4)}, 3},
+	} {
+		whole := New(data[0].T, tt.opts...)
+		for _, p := range data {
+			whole.Push(p.T, p.V)
+		}
+		whole.Finish()
+
+		empty := New(data[0].T, tt.opts...)
+		for _, p := range data[:tt.held] {
+			empty.Push(p.T, p.V)
+		}
+		b, err := empty.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := New(data[0].T, tt.opts...)
+		if err := s.UnmarshalBinary(b); err != nil {
+			t.Fatalf("UnmarshalBinary(empty series)=%v", err)
+		}
+		for _, p := range data[tt.held:] {
+			s.Push(p.T, p.V)
+		}
+		s.Finish()
+
+		if !bytes.Equal(s.Bytes(), whole.Bytes()) {
+			t.Errorf("%d options: series unmarshaled empty differs from one never marshaled", len(tt.opts))
+		}
+	}
+
+	// a finished empty series stays finished
+	for _, opts := range [][]Option{nil, {WithHeader()}, {WithIndex(4), WithChecksum()}} {
+		s := New(data[0].T, opts...)
+		s.Finish()
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s = New(data[0].T, opts...)
+		if err := s.UnmarshalBinary(b); err != nil {
+			t.Fatalf("UnmarshalBinary(finished empty series)=%v", err)
+		}
+		if err := s.Push(data[0].T, 1); err != ErrFinished {
+			t.Errorf("%d options: Push(finished empty series)=%v, want %v", len(opts), err, ErrFinished)
+		}
+	}
+}
+
 func BenchmarkMarshalBinary(b *testing.B) {
 	var err error
 	b.StopTimer()
@@ -254,27 +359,152 @@ func testConcurrentRoundtrip(t *testing.T, sleep time.Duration) {
 	done <- struct{}{}
 }
 
-func BenchmarkEncode(b *testing.B) {
-	b.SetBytes(int64(len(testdata.TwoHoursData) * 12))
-	for i := 0; i < b.N; i++ {
-		s := New(testdata.TwoHoursData[0].T)
-		for _, tt := range testdata.TwoHoursData {
-			s.Push(tt.T, tt.V)
+func TestSnapshotIter(t *testing.T) {
+	data := testdata.TwoHoursData
+	s := New(data[0].T, WithIndex(8), WithChecksum())
+
+	var its []*Iter
+	for i, p := range data {
+		its = append(its, s.Iter())
+		s.Push(p.T, p.V)
+		if i == len(data)/2 {
+			its = append(its, s.Iter())
+			s.Finish()
+			break
+		}
+	}
+
+	// each iterator sees the points pushed before it was made
+	for n, it := range its {
+		var read int
+		for it.Next() {
+			tt, vv := it.Values()
+			if w := data[read]; tt != w.T || vv != w.V {
+				t.Errorf("snapshot %d: point %d=(%v,%v), want (%v,%v)", n, read, tt, vv, w.T, w.V)
+			}
+			read++
+		}
+		if read != n || it.Err() != nil {
+			t.Errorf("snapshot %d: read %d points, err=%v; want %d, nil", n, read, it.Err(), n)
 		}
 	}
 }
 
+func TestSnapshotIterWhileFinishing(t *testing.T) {
+	data := testdata.TwoHoursData
+	s := New(data[0].T, WithHeader())
+	for _, p := range data[:100] {
+		s.Push(p.T, p.V)
+	}
+
+	it := s.Iter()
+	done := make(chan struct{})
+	go func() {
+		// fills in the point count of the header being read
+		s.Finish()
+		close(done)
+	}()
+
+	var read int
+	for it.Next() {
+		read++
+	}
+	<-done
+	if read != 100 || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want 100, nil", read, it.Err())
+	}
+}
+
+func TestSnapshotIterAllocs(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T)
+	for i := 0; i < 10000; i++ {
+		s.Push(s.T0+uint32(i)*60, float64(i%100))
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.Iter()
+	})
+	// the iterator and the pending bits, not a copy of the stream
+	if allocs > 2 {
+		t.Errorf("Iter() on an open series made %v allocations, want at most 2", allocs)
+	}
+}
+
+// benchSeries are the series the encode and decode benchmarks run on: the
+// two hours from the paper, and a day of values a minute apart that vary
+// more, so that most bits go through the stream in wide fields
+var benchSeries = []struct {
+	name   string
+	points []testdata.Point
+}{
+	{"2h", testdata.TwoHoursData},
+	{"1d", dayData()},
+}
+
+func dayData() []testdata.Point {
+	r := rand.New(rand.NewSource(1))
+	t, v := testdata.TwoHoursData[0].T, 100.0
+	var points []testdata.Point
+	for i := 0; i < 1440; i++ {
+		t += 60
+		v += math.Round(r.NormFloat64()*100) / 100
+		points = append(points, testdata.Point{T: t, V: v})
+	}
+	return points
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, bs := range benchSeries {
+		b.Run(bs.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bs.points) * 12))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := New(bs.points[0].T)
+				for _, p := range bs.points {
+					s.Push(p.T, p.V)
+				}
+				s.Finish()
+			}
+		})
+	}
+}
+
 func BenchmarkDecodeSeries(b *testing.B) {
+	for _, bs := range benchSeries {
+		b.Run(bs.name, func(b *testing.B) {
+			s := New(bs.points[0].T)
+			for _, p := range bs.points {
+				s.Push(p.T, p.V)
+			}
+			b.SetBytes(int64(len(bs.points) * 12))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				it := s.Iter()
+				var sum float64
+				for it.Next() {
+					_, v := it.Values()
+					sum += v
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeByteSlice(b *testing.B) {
 	b.SetBytes(int64(len(testdata.TwoHoursData) * 12))
 	s := New(testdata.TwoHoursData[0].T)
 	for _, tt := range testdata.TwoHoursData {
 		s.Push(tt.T, tt.V)
 	}
 
+	s.Finish()
+	buf := s.Bytes()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		it := s.Iter()
+		it, _ := NewIterator(buf)
 		var j int
 		for it.Next() {
 			j++
@@ -282,21 +512,103 @@ func BenchmarkDecodeSeries(b *testing.B) {
 	}
 }
 
-func BenchmarkDecodeByteSlice(b *testing.B) {
-	b.SetBytes(int64(len(testdata.TwoHoursData) * 12))
+func TestConcurrentDecodeSharedBytes(t *testing.T) {
 	s := New(testdata.TwoHoursData[0].T)
-	for _, tt := range testdata.TwoHoursData {
-		s.Push(tt.T, tt.V)
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
 	}
+	s.Finish()
+
+	b := s.Bytes()
+	orig := append([]byte(nil), b...)
 
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			it, err := NewIterator(b)
+			if err != nil {
+				errs <- err
+				return
+			}
+			var n int
+			for it.Next() {
+				tt, vv := it.Values()
+				if w := testdata.TwoHoursData[n]; w.T != tt || w.V != vv {
+					errs <- fmt.Errorf("Values()=(%v,%v), want (%v,%v)", tt, vv, w.T, w.V)
+					return
+				}
+				n++
+			}
+			if n != len(testdata.TwoHoursData) {
+				errs <- fmt.Errorf("read %d points, want %d", n, len(testdata.TwoHoursData))
+				return
+			}
+			errs <- it.Err()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	if !bytes.Equal(b, orig) {
+		t.Errorf("decoding modified the block")
+	}
+}
+
+func TestConcurrentBytes(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T)
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	// leave bits pending, which Bytes adds after the flushed ones
+	if s.bw.count == 0 {
+		s.Push(testdata.TwoHoursData[0].T+1e5, 1)
+	}
+	want := append([]byte(nil), s.Bytes()...)
+
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				if b := s.Bytes(); !bytes.Equal(b, want) {
+					errs <- fmt.Errorf("Bytes() changed while open")
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func BenchmarkEncode64(b *testing.B) {
+	b.SetBytes(int64(len(testdata.TwoHoursData) * 16))
+	for i := 0; i < b.N; i++ {
+		s := New64(int64(testdata.TwoHoursData[0].T)*1e3, Millisecond)
+		for j, tt := range testdata.TwoHoursData {
+			s.Push64(int64(tt.T)*1e3+int64(j%7), tt.V)
+		}
+	}
+}
+
+func BenchmarkDecode64(b *testing.B) {
+	b.SetBytes(int64(len(testdata.TwoHoursData) * 16))
+	s := New64(int64(testdata.TwoHoursData[0].T)*1e3, Millisecond)
+	for j, tt := range testdata.TwoHoursData {
+		s.Push64(int64(tt.T)*1e3+int64(j%7), tt.V)
+	}
 	s.Finish()
-	bytes := s.Bytes()
-	buf := make([]byte, len(bytes))
+	buf := s.Bytes()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		copy(buf, bytes)
-		it, _ := NewIterator(buf)
+		it, _ := NewIterator64(buf, Millisecond)
 		var j int
 		for it.Next() {
 			j++
@@ -347,8 +659,529 @@ func TestEncodeSimilarFloats(t *testing.T) {
 
 func TestBstreamIteratorError(t *testing.T) {
 	b := newBReader([]byte(""))
-	_, err := bstreamIterator(b)
+	_, err := bstreamIterator(b, format{})
 	if err == nil {
 		t.Errorf("An error was expected")
 	}
 }
+
+func TestRoundtrip64(t *testing.T) {
+
+	for _, unit := range []Unit{Second, Millisecond, Microsecond, Nanosecond} {
+		scale := [...]int64{1, 1e3, 1e6, 1e9}[unit]
+
+		// 10 second scrapes with jitter of a few milliseconds
+		t0 := int64(testdata.TwoHoursData[0].T) * scale
+		s := New64(t0, unit)
+		var want []int64
+		for i, p := range testdata.TwoHoursData {
+			tt := int64(p.T) * scale
+			if unit != Second {
+				tt += int64(i%7) * scale / 1000
+			}
+			want = append(want, tt)
+			s.Push64(tt, p.V)
+		}
+
+		b, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s2 := New64(0, unit)
+		if err := s2.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		if s2.Start() != t0 {
+			t.Errorf("unit=%v Start()=%v, want %v", unit, s2.Start(), t0)
+		}
+
+		s.Finish()
+		it, err := NewIterator64(s.Bytes(), unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range testdata.TwoHoursData {
+			if !it.Next() {
+				t.Fatalf("unit=%v Next()=false, want true", unit)
+			}
+			tt, vv := it.Values64()
+			if want[i] != tt || w.V != vv {
+				t.Errorf("unit=%v Values64()=(%v,%v), want (%v,%v)\n", unit, tt, vv, want[i], w.V)
+			}
+		}
+
+		if it.Next() {
+			t.Fatalf("unit=%v Next()=true, want false", unit)
+		}
+
+		if err := it.Err(); err != nil {
+			t.Errorf("unit=%v it.Err()=%v, want nil", unit, err)
+		}
+	}
+}
+
+func TestEncode64LargeDeltas(t *testing.T) {
+
+	t0 := time.Date(2015, 3, 24, 2, 0, 0, 0, time.UTC).UnixNano()
+	s := New64(t0, Nanosecond)
+
+	want := []int64{
+		t0 + 1,
+		t0 + 1 + int64(time.Second),
+		t0 + 1 + 2*int64(time.Second) + 200,
+		t0 + 1 + 3*int64(time.Second) + 5000000,
+		t0 + 1 + 4*int64(time.Second),
+		t0 + 1 + 400*int64(time.Hour),
+		t0 + 2 + 400*int64(time.Hour),
+	}
+
+	for i, tt := range want {
+		s.Push64(tt, float64(i))
+	}
+
+	it := s.Iter()
+	for i, w := range want {
+		if !it.Next() {
+			t.Fatalf("Next()=false, want true")
+		}
+		tt, vv := it.Values64()
+		if w != tt || float64(i) != vv {
+			t.Errorf("Values64()=(%v,%v), want (%v,%v)\n", tt, vv, w, i)
+		}
+	}
+
+	if it.Next() {
+		t.Fatalf("Next()=true, want false")
+	}
+
+	if err := it.Err(); err != nil {
+		t.Errorf("it.Err()=%v, want nil", err)
+	}
+}
+
+func TestPushErrors(t *testing.T) {
+
+	t0 := testdata.TwoHoursData[0].T
+	s := New(t0)
+
+	if err := s.Push(t0-1, 1); err != ErrOutOfOrder {
+		t.Errorf("Push(before T0)=%v, want %v", err, ErrOutOfOrder)
+	}
+	if err := s.Push(t0+1<<14, 1); err != ErrFirstDeltaOverflow {
+		t.Errorf("Push(T0+1<<14)=%v, want %v", err, ErrFirstDeltaOverflow)
+	}
+
+	if err := s.Push(t0+60, 1); err != nil {
+		t.Fatalf("Push()=%v, want nil", err)
+	}
+	if err := s.Push(t0+120, 2); err != nil {
+		t.Fatalf("Push()=%v, want nil", err)
+	}
+
+	before := append([]byte(nil), s.Bytes()...)
+	if err := s.Push(t0+119, 3); err != ErrOutOfOrder {
+		t.Errorf("Push(out of order)=%v, want %v", err, ErrOutOfOrder)
+	}
+	if !bytes.Equal(before, s.Bytes()) {
+		t.Errorf("rejected point modified the stream")
+	}
+
+	// equal timestamps are allowed
+	if err := s.Push(t0+120, 3); err != nil {
+		t.Fatalf("Push()=%v, want nil", err)
+	}
+
+	s.Finish()
+	before = append([]byte(nil), s.Bytes()...)
+	if err := s.Push(t0+180, 4); err != ErrFinished {
+		t.Errorf("Push(after Finish)=%v, want %v", err, ErrFinished)
+	}
+	if !bytes.Equal(before, s.Bytes()) {
+		t.Errorf("rejected point modified the stream")
+	}
+
+	it, _ := NewIterator(s.Bytes())
+	want := []struct {
+		t uint32
+		v float64
+	}{
+		{t0 + 60, 1},
+		{t0 + 120, 2},
+		{t0 + 120, 3},
+	}
+	for _, w := range want {
+		if !it.Next() {
+			t.Fatalf("Next()=false, want true")
+		}
+		tt, vv := it.Values()
+		if w.t != tt || w.v != vv {
+			t.Errorf("Values()=(%v,%v), want (%v,%v)\n", tt, vv, w.t, w.v)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("Next()=true, want false")
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("it.Err()=%v, want nil", err)
+	}
+}
+
+func TestFirstDeltaWide(t *testing.T) {
+	for _, tt := range []struct {
+		s      *Series
+		t0, t1 int64
+	}{
+		// a day after a block aligned to midnight
+		{New(1500076800, WithHeader()), 1500076800, 1500076800 + 86400},
+		{New(0, WithHeader()), 0, 1<<32 - 1},
+		{New(0, WithHeader()), 0, 1<<14 - 1},
+		{New(0, WithHeader()), 0, 1 << 14},
+		{New64(1500076800e9, Nanosecond, WithHeader()), 1500076800e9, 1500076800e9 + 86400e9},
+		{New64(-1<<63, Nanosecond, WithHeader()), -1 << 63, 1<<63 - 1},
+	} {
+		if err := tt.s.Push64(tt.t1, 1); err != nil {
+			t.Fatalf("Push64(T0+%d)=%v", uint64(tt.t1-tt.t0), err)
+		}
+		tt.s.Push64(tt.t1, 2)
+
+		// open and finished
+		for i := 0; i < 2; i++ {
+			it := tt.s.Iter()
+			var got []int64
+			for it.Next() {
+				ts, _ := it.Values64()
+				got = append(got, ts)
+			}
+			if len(got) != 2 || got[0] != tt.t1 || got[1] != tt.t1 || it.Err() != nil {
+				t.Errorf("T0+%d: read %v, err=%v; want [%d %d], nil", uint64(tt.t1-tt.t0), got, it.Err(), tt.t1, tt.t1)
+			}
+			tt.s.Finish()
+		}
+	}
+}
+
+func TestZeroTimestamps(t *testing.T) {
+
+	want := []struct {
+		t uint32
+		v float64
+	}{
+		{0, 1},
+		{0, 2},
+		{60, 3},
+		{120, 4},
+	}
+
+	s := New(0)
+	for _, w := range want {
+		if err := s.Push(w.t, w.v); err != nil {
+			t.Fatalf("Push()=%v, want nil", err)
+		}
+	}
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := New(0)
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	// a point at T0 must not look like the first point after a round trip
+	if err := s2.Push(180, 5); err != nil {
+		t.Fatalf("Push()=%v, want nil", err)
+	}
+	want = append(want, struct {
+		t uint32
+		v float64
+	}{180, 5})
+
+	it := s2.Iter()
+	for _, w := range want {
+		if !it.Next() {
+			t.Fatalf("Next()=false, want true")
+		}
+		tt, vv := it.Values()
+		if w.t != tt || w.v != vv {
+			t.Errorf("Values()=(%v,%v), want (%v,%v)\n", tt, vv, w.t, w.v)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("Next()=true, want false")
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("it.Err()=%v, want nil", err)
+	}
+
+	// relative timeline starting at zero
+	s64 := New64(0, Millisecond)
+	for i := int64(0); i < 10; i++ {
+		s64.Push64(i*1000, float64(i))
+	}
+	it = s64.Iter()
+	for i := int64(0); i < 10; i++ {
+		if !it.Next() {
+			t.Fatalf("Next()=false, want true")
+		}
+		tt, vv := it.Values64()
+		if i*1000 != tt || float64(i) != vv {
+			t.Errorf("Values64()=(%v,%v), want (%v,%v)\n", tt, vv, i*1000, i)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("Next()=true, want false")
+	}
+}
+
+// codecTests are the options every float codec test runs with: each codec,
+// and the options that change how a codec chooses what it writes
+var codecTests = [][]Option{
+	{},
+	{WithIntegerRuns()},
+	{WithChimp()},
+	{WithChimp128()},
+	{WithDecimal()},
+	{WithWindowReuse()},
+	{WithLookahead(1)},
+	{WithLookahead(16)},
+	{WithIntegerRuns(), WithLookahead(4)},
+	{WithChimp(), WithLookahead(4)},
+	{WithDecimal(), WithLookahead(4)},
+	{WithWideLeading()},
+	{WithWideLeading(), WithIntegerRuns()},
+	{WithWideLeading(), WithDecimal(), WithLookahead(4)},
+}
+
+// withOpts returns opts followed by more, leaving opts as it is
+func withOpts(opts []Option, more ...Option) []Option {
+	return append(opts[:len(opts):len(opts)], more...)
+}
+
+func TestCodecBitExact(t *testing.T) {
+	for _, opts := range codecTests {
+		checkBitExact(t, testValues(), opts...)
+		checkBitExact(t, testValues(), withOpts(opts, WithIndex(3), WithChecksum())...)
+	}
+}
+
+func TestCodecSeek(t *testing.T) {
+	at := []int{1, 2, 4, 6, 7, 13, 14, 31, 40, 50, 199, 250, 251, 300, 301, 350, 600, 700, 900}
+	for _, opts := range codecTests {
+		checkSeek(t, testValues(), at, withOpts(opts, WithIndex(5))...)
+	}
+}
+
+func TestCodecMarshalBinary(t *testing.T) {
+	for _, opts := range codecTests {
+		checkMarshalBinary(t, testValues(), opts...)
+	}
+}
+
+func TestCodecSmaller(t *testing.T) {
+	levels := []float64{0, 1.25, 17.3, 99.9, -4.1}
+	// whose meaningful bits vary in width
+	randomWalk := walk(3, 100, func(r *rand.Rand, v float64) float64 { return v + r.NormFloat64() })
+
+	for _, tt := range []struct {
+		name       string
+		vals       []float64
+		base, opts []Option
+		most       float64 // the share of the base size that opts may take
+	}{
+		{"integer runs on uniform integers", walk(1, 0, func(r *rand.Rand, _ float64) float64 {
+			return math.Floor(r.Float64() * 60000)
+		}), nil, []Option{WithIntegerRuns()}, 1},
+		// deltas much narrower than the values
+		{"integer runs on an integer random walk", walk(1, 30000, func(r *rand.Rand, v float64) float64 {
+			return v + math.Floor(r.NormFloat64()*100)
+		}), nil, []Option{WithIntegerRuns()}, 1},
+		{"Chimp on random decimals", walk(1, 0, func(r *rand.Rand, _ float64) float64 {
+			return math.Floor(r.Float64()*10000) / 100
+		}), nil, []Option{WithChimp()}, 1},
+		// every value after the first few is in the ring
+		{"Chimp128 on values from a small set", walk(1, 0, func(r *rand.Rand, _ float64) float64 {
+			return levels[r.Intn(len(levels))]
+		}), []Option{WithChimp()}, []Option{WithChimp128()}, 0.5},
+		{"decimals on a decimal random walk", walk(1, 20, func(r *rand.Rand, v float64) float64 {
+			return math.Round((v+r.NormFloat64())*100) / 100
+		}), nil, []Option{WithDecimal()}, 0.5},
+		{"choosing windows by cost on a random walk", randomWalk, []Option{WithWindowReuse()}, nil, 1},
+		{"lookahead on a random walk", randomWalk, nil, []Option{WithLookahead(8)}, 1},
+		{"6-bit leading zeros on a slow large counter", slowLarge(), []Option{WithHeader()}, []Option{WithWideLeading()}, 1},
+	} {
+		size := func(opts ...Option) int {
+			s := New(0, opts...)
+			pushValues(s, tt.vals, 0, len(tt.vals), 60)
+			s.Finish()
+			return len(s.Bytes())
+		}
+
+		if base, got := size(tt.base...), size(tt.opts...); float64(got) >= tt.most*float64(base) {
+			t.Errorf("%s: took %d bytes, %d otherwise", tt.name, got, base)
+		}
+	}
+}
+
+// walk returns 2000 values starting from v, each made by next from the one
+// before it, with random numbers from the given seed
+func walk(seed int64, v float64, next func(r *rand.Rand, v float64) float64) []float64 {
+	r := rand.New(rand.NewSource(seed))
+	vals := make([]float64, 2000)
+	for i := range vals {
+		v = next(r, v)
+		vals[i] = v
+	}
+	return vals
+}
+
+// slowLarge is a large counter growing by small steps, whose XORs have more
+// than 31 leading zeros
+func slowLarge() []float64 {
+	return walk(4, 1e9, func(r *rand.Rand, v float64) float64 { return v + r.ExpFloat64()*0.1 })
+}
+
+// testValues are values every float codec must store bit for bit: special
+// and extreme values, runs of integers, decimals of varying precision, random
+// bits, the values of the paper and a large counter growing slowly
+func testValues() []float64 {
+	vals := []float64{
+		5, 5, 5, 7, 8, 8, 1e6, -3,
+		math.Copysign(0, -1), 0, math.Copysign(0, -1), 1, -1,
+		math.Inf(1), 3, math.Inf(-1), 4, math.NaN(),
+		math.Float64frombits(0x7ff8000000000001), 7,
+		math.Float64frombits(0xfff0000000000001), 7, 8,
+		1 << 63, -(1 << 63), 1<<63 - 1024, -(1 << 63), 1 << 53, 1<<53 + 2,
+		math.MaxFloat64, -math.MaxFloat64, 5e-324, -5e-324, 1e300,
+		0.5, 1.5, 2, 2.25, 3, 20.5, 20.5, 20.25, 19.75, 21,
+		0.1, 0.2, 0.30000000000000004, 0.3, 123456.789012345, 0.000000000000001,
+		99.99, -99.99, 1e-7, 3.14159,
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		switch i / 100 {
+		case 0, 2:
+			vals = append(vals, math.Floor(r.Float64()*1000))
+		case 1:
+			vals = append(vals, r.NormFloat64())
+		case 3:
+			vals = append(vals, float64(i*i))
+		case 4:
+			vals = append(vals, r.Float64()*100, math.Float64frombits(r.Uint64()))
+		case 5, 6:
+			vals = append(vals, math.Round(r.Float64()*10000)/100)
+		case 7:
+			vals = append(vals, math.Round(r.NormFloat64()*1000)/10)
+		case 8:
+			vals = append(vals, math.Round(r.Float64()*1e6)/1e3)
+		default:
+			vals = append(vals, float64(r.Intn(100))+float64(r.Intn(1000))/1000)
+		}
+	}
+	for _, p := range testdata.TwoHoursData {
+		vals = append(vals, p.V)
+	}
+	return append(vals, slowLarge()...)
+}
+
+// pushValues pushes vals[from:to] to s, value i at i*step seconds
+func pushValues(s *Series, vals []float64, from, to, step int) {
+	for i := from; i < to; i++ {
+		s.Push(uint32(i*step), vals[i])
+	}
+}
+
+// checkValues checks that it reads back vals bit for bit
+func checkValues(t *testing.T, it *Iter, vals []float64) {
+	var n int
+	for it.Next() {
+		_, v := it.Values()
+		if n < len(vals) && math.Float64bits(v) != math.Float64bits(vals[n]) {
+			t.Errorf("point %d=%v (%#x), want %v (%#x)", n, v, math.Float64bits(v), vals[n], math.Float64bits(vals[n]))
+		}
+		n++
+	}
+	if n != len(vals) || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want %d, nil", n, it.Err(), len(vals))
+	}
+}
+
+// checkBitExact checks that a finished series of vals passes Verify and reads
+// back vals bit for bit
+func checkBitExact(t *testing.T, vals []float64, opts ...Option) {
+	s := New(0, opts...)
+	pushValues(s, vals, 0, len(vals), 1)
+	s.Finish()
+
+	if err := Verify(s.Bytes()); err != nil {
+		t.Fatalf("Verify()=%v", err)
+	}
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, it, vals)
+}
+
+// checkSeek checks that Seek finds the points at the given positions, and the
+// last point, in both an open and a finished series of vals
+func checkSeek(t *testing.T, vals []float64, at []int, opts ...Option) {
+	s := New(0, opts...)
+	pushValues(s, vals, 0, len(vals), 10)
+
+	check := func(name string, it *Iter, n int) {
+		for _, i := range append(at[:len(at):len(at)], n-1) {
+			if !it.Seek(uint32(i * 10)) {
+				t.Fatalf("%s: Seek(point %d)=false, want true", name, i)
+			}
+			if _, v := it.Values(); math.Float64bits(v) != math.Float64bits(vals[i]) {
+				t.Errorf("%s: Seek(point %d) found %v, want %v", name, i, v, vals[i])
+			}
+		}
+	}
+
+	// without the points held back for lookahead
+	check("open", s.Iter(), len(vals)-len(s.pending))
+	s.Finish()
+	it, _ := NewIterator(s.Bytes())
+	check("finished", it, len(vals))
+}
+
+// marshalHalf pushes the first half of vals to a series, and returns it with
+// the series it is marshaled and unmarshaled into
+func marshalHalf(t *testing.T, vals []float64, opts ...Option) (*Series, *Series) {
+	s := New(0, opts...)
+	pushValues(s, vals, 0, len(vals)/2, 1)
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := New(0, opts...)
+	if err := s2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	return s, s2
+}
+
+// checkMarshalBinary checks that a series of vals marshaled and unmarshaled
+// halfway through writes the same bytes as one never marshaled, and reads
+// back vals bit for bit
+func checkMarshalBinary(t *testing.T, vals []float64, opts ...Option) {
+	whole := New(0, opts...)
+	pushValues(whole, vals, 0, len(vals), 1)
+	whole.Finish()
+
+	_, s := marshalHalf(t, vals, opts...)
+	pushValues(s, vals, len(vals)/2, len(vals), 1)
+	s.Finish()
+
+	if !bytes.Equal(s.Bytes(), whole.Bytes()) {
+		t.Error("series unmarshaled halfway through differs from one never marshaled")
+	}
+	it, err := NewIterator(s.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, it, vals)
+}
@@ -0,0 +1,164 @@
+package tsz
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A block with a checksum has a trailer after its end-of-stream record:
+// zero padding up to a byte boundary followed by the CRC-32C (Castagnoli) of
+// every byte before the checksum, header included.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when a block doesn't match its checksum
+var ErrChecksum = errors.New("tsz: checksum mismatch")
+
+// WithChecksum makes Finish append a CRC32 of the block, which iterators
+// check when they reach the end of the stream.  It implies WithHeader.
+func WithChecksum() Option {
+	return func(f *format) {
+		f.framed = true
+		f.checksum = true
+	}
+}
+
+func writeChecksum(w *bstream) {
+	w.pad()
+	w.writeBits(uint64(crc32.Checksum(w.bytes(), castagnoli)), 32)
+}
+
+// readTrailer reads what the format puts after the end-of-stream record.  The
+// iterator is only advanced past it if it is intact.
+func (it *Iter) readTrailer() error {
+	if !it.fmt.indexed && !it.fmt.checksum {
+		return nil
+	}
+
+	br := it.br
+
+	// skip the bit closing the end-of-stream record
+	if !it.fmt.escaped() {
+		if _, err := br.readBit(); err != nil {
+			return err
+		}
+	}
+
+	if pad := (8 - br.offset()%8) % 8; pad != 0 {
+		if _, err := br.readBits(pad); err != nil {
+			return err
+		}
+	}
+
+	if it.fmt.indexed {
+		if err := it.skipIndex(&br); err != nil {
+			return err
+		}
+	}
+
+	if !it.fmt.checksum {
+		it.br = br
+		return nil
+	}
+
+	end := br.offset() / 8
+	sum, err := br.readBits(32)
+	if err != nil {
+		return err
+	}
+
+	if uint32(sum) != crc32.Checksum(br.stream[:end], castagnoli) {
+		return ErrChecksum
+	}
+
+	it.br = br
+	return nil
+}
+
+// A DecodeError reports where a damaged block stopped decoding
+type DecodeError struct {
+	Point  int   // index of the point being decoded
+	Offset int   // offset in bits at which decoding failed
+	Err    error // the reason
+
+	// Finished is set if the end-of-stream record was reached, so the block
+	// was completely written but later damaged.  Otherwise it was cut short,
+	// for example by a writer that crashed.
+	Finished bool
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("tsz: decoding point %d failed at bit %d: %v", e.Point, e.Offset, e.Err)
+}
+
+// A Point is a timestamp and value from a series
+type Point struct {
+	T int64
+	V float64
+}
+
+// Verify reports whether b is an intact, finished block.  It decodes the
+// whole block, checking the end-of-stream record, the point count and the
+// checksum if the block has them, and that nothing follows the end of the
+// block.  A damaged block is reported with a *DecodeError.  Blocks without a
+// header must have 32-bit timestamps.
+func Verify(b []byte) error {
+	return decodeBlock(b, nil)
+}
+
+// Salvage decodes a block that may be truncated or corrupt.  It returns every
+// point decoded before decoding failed, and a *DecodeError describing where
+// it failed if the block isn't intact, as reported by Verify.
+//
+// Corruption is only noticed once it makes the stream undecodable or, for
+// blocks with a checksum, at the end of the block, so points decoded before
+// the error was found may already be wrong.  The last byte of a block cut
+// short mid-byte is padded with zero bits, which decode as repeats of the
+// last point.
+func Salvage(b []byte) ([]Point, error) {
+	var points []Point
+	err := decodeBlock(b, func(t int64, v float64) {
+		points = append(points, Point{T: t, V: v})
+	})
+	return points, err
+}
+
+// decodeBlock decodes b, passing each point to fn if it isn't nil
+func decodeBlock(b []byte, fn func(t int64, v float64)) error {
+	it, err := NewIterator(b)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return &DecodeError{Err: err}
+	}
+
+	for it.Next() {
+		if fn != nil {
+			fn(it.Values64())
+		}
+	}
+
+	err = it.Err()
+	if err == nil && !it.finished {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && it.br.remaining() > 8 {
+		// more than the bit closing the end-of-stream record and the padding
+		err = ErrCorrupt
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return &DecodeError{
+			Point:    it.n,
+			Offset:   it.br.offset(),
+			Err:      err,
+			Finished: it.finished,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package tsz
+
+import (
+	"io"
+	"testing"
+
+	"github.com/dgryski/go-tsz/testdata"
+)
+
+func checksummedBlock() []byte {
+	s := New(testdata.TwoHoursData[0].T, WithChecksum())
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+	return s.Bytes()
+}
+
+func TestChecksumRoundtrip(t *testing.T) {
+	b := checksummedBlock()
+
+	if err := Verify(b); err != nil {
+		t.Fatalf("Verify()=%v, want nil", err)
+	}
+
+	it, err := NewIterator(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for it.Next() {
+		tt, vv := it.Values()
+		if w := testdata.TwoHoursData[n]; w.T != tt || w.V != vv {
+			t.Errorf("Values()=(%v,%v), want (%v,%v)\n", tt, vv, w.T, w.V)
+		}
+		n++
+	}
+	if n != len(testdata.TwoHoursData) {
+		t.Errorf("read %d points, want %d", n, len(testdata.TwoHoursData))
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("it.Err()=%v, want nil", err)
+	}
+}
+
+func TestChecksumOpenSeries(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T, WithChecksum())
+	for _, p := range testdata.TwoHoursData[:10] {
+		s.Push(p.T, p.V)
+	}
+
+	it := s.Iter()
+	var n int
+	for it.Next() {
+		n++
+	}
+	if n != 10 || it.Err() != nil {
+		t.Errorf("read %d points, err=%v; want 10, nil", n, it.Err())
+	}
+
+	// no end-of-stream record yet
+	err := Verify(s.Bytes())
+	if e, ok := err.(*DecodeError); !ok || e.Err != io.ErrUnexpectedEOF {
+		t.Errorf("Verify(open series)=%v, want unexpected EOF", err)
+	}
+}
+
+func TestVerifyBitFlips(t *testing.T) {
+	good := checksummedBlock()
+
+	// every bit after the magic is covered by the checksum
+	for i := 32; i < len(good)*8; i++ {
+		b := append([]byte(nil), good...)
+		b[i/8] ^= 0x80 >> uint(i%8)
+
+		err := Verify(b)
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("bit %d flipped: Verify()=%v, want *DecodeError", i, err)
+		}
+		if e.Offset < 0 || e.Offset > len(b)*8 {
+			t.Errorf("bit %d flipped: Offset=%d out of range", i, e.Offset)
+		}
+	}
+}
+
+func TestVerifyTruncated(t *testing.T) {
+	good := checksummedBlock()
+
+	for i := 0; i < len(good); i++ {
+		err := Verify(good[:i])
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("truncated to %d bytes: Verify()=%v, want *DecodeError", i, err)
+		}
+		if e.Offset > i*8 {
+			t.Errorf("truncated to %d bytes: Offset=%d past the end", i, e.Offset)
+		}
+	}
+}
+
+func TestVerifyHeaderless(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T)
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+
+	if err := Verify(s.Bytes()); err != nil {
+		t.Errorf("Verify()=%v, want nil", err)
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T, WithChecksum())
+	s.Finish()
+
+	if err := Verify(s.Bytes()); err != nil {
+		t.Errorf("Verify(empty block)=%v, want nil", err)
+	}
+	if points, err := Salvage(s.Bytes()); len(points) != 0 || err != nil {
+		t.Errorf("Salvage(empty block)=%d points, %v; want 0, nil", len(points), err)
+	}
+}
+
+func TestUnmarshalFinished(t *testing.T) {
+	s := New(testdata.TwoHoursData[0].T, WithChecksum())
+	for _, p := range testdata.TwoHoursData {
+		s.Push(p.T, p.V)
+	}
+	s.Finish()
+
+	m, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := New(0, WithChecksum())
+	if err := s2.UnmarshalBinary(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.Push(testdata.TwoHoursData[len(testdata.TwoHoursData)-1].T+60, 1); err != ErrFinished {
+		t.Errorf("Push()=%v, want %v", err, ErrFinished)
+	}
+	if err := Verify(s2.Bytes()); err != nil {
+		t.Errorf("Verify()=%v, want nil", err)
+	}
+}
+
+func TestSalvageComplete(t *testing.T) {
+	points, err := Salvage(checksummedBlock())
+	if err != nil {
+		t.Fatalf("Salvage()=%v, want nil", err)
+	}
+	if len(points) != len(testdata.TwoHoursData) {
+		t.Fatalf("Salvage() returned %d points, want %d", len(points), len(testdata.TwoHoursData))
+	}
+	for i, p := range points {
+		if w := testdata.TwoHoursData[i]; int64(w.T) != p.T || w.V != p.V {
+			t.Errorf("point %d=%v, want (%v,%v)", i, p, w.T, w.V)
+		}
+	}
+}
+
+func TestSalvageTruncated(t *testing.T) {
+	good := checksummedBlock()
+
+	// a prefix of the block, as left by a writer that crashed before writing
+	// the end-of-stream record and the checksum
+	for i := 20; i < len(good)-9; i += 7 {
+		points, err := Salvage(good[:i])
+
+		e, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("truncated to %d bytes: Salvage()=%v, want *DecodeError", i, err)
+		}
+		if e.Finished {
+			t.Errorf("truncated to %d bytes: Finished=true, want false", i)
+		}
+		if e.Err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated to %d bytes: Err=%v, want %v", i, e.Err, io.ErrUnexpectedEOF)
+		}
+		if e.Point != len(points) {
+			t.Errorf("truncated to %d bytes: Point=%d, want %d", i, e.Point, len(points))
+		}
+		if e.Offset > i*8 {
+			t.Errorf("truncated to %d bytes: Offset=%d past the end", i, e.Offset)
+		}
+
+		for j, p := range points {
+			w := testdata.TwoHoursData[j]
+			if int64(w.T) == p.T && w.V == p.V {
+				continue
+			}
+			// zero padding in the last byte decodes as repeats of the last point
+			if j > 0 && j >= len(points)-3 && points[j-1] == p {
+				continue
+			}
+			t.Errorf("truncated to %d bytes: point %d=%v, want (%v,%v)", i, j, p, w.T, w.V)
+		}
+	}
+}
+
+func TestSalvageChecksumMismatch(t *testing.T) {
+	b := checksummedBlock()
+	b[len(b)-1] ^= 1
+
+	points, err := Salvage(b)
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("Salvage()=%v, want *DecodeError", err)
+	}
+	if !e.Finished || e.Err != ErrChecksum {
+		t.Errorf("Salvage()=%v, want finished block with %v", err, ErrChecksum)
+	}
+	if len(points) != len(testdata.TwoHoursData) || e.Point != len(points) {
+		t.Errorf("Salvage() returned %d points, Point=%d; want %d", len(points), e.Point, len(testdata.TwoHoursData))
+	}
+}
+
+func TestSalvageBadWindow(t *testing.T) {
+	w := newBWriter(0)
+	w.writeBits(0, 32)                  // T0
+	w.writeBits(60, 14)                 // first delta
+	w.writeBits(0x4000000000000000, 64) // first value
+	w.writeBits(0, 1)                   // dod 0
+	w.writeBits(0x03, 2)                // new window
+	w.writeBits(31, 5)                  // leading
+	w.writeBits(40, 6)                  // 31+40 significant bits don't fit
+
+	points, err := Salvage(w.bytes())
+	e, ok := err.(*DecodeError)
+	if !ok || e.Err != ErrCorrupt {
+		t.Fatalf("Salvage()=%v, want *DecodeError with %v", err, ErrCorrupt)
+	}
+	if len(points) != 1 || e.Point != 1 || e.Offset != 32+14+64+1+2+5+6 {
+		t.Errorf("Salvage() returned %d points, Point=%d, Offset=%d; want 1, 1, %d", len(points), e.Point, e.Offset, 32+14+64+1+2+5+6)
+	}
+}
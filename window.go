@@ -0,0 +1,159 @@
+package tsz
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The XOR codec stores a value that changed either in the window of
+// meaningful bits of the previous one, behind '10', or in a new window
+// described by its leading zeros and length, behind '11':
+//
+//	'11' + leading 5 or 6 bits + length 6 bits + meaningful bits
+//
+// The leading zeros take 5 bits unless the block header has flagWideLeading,
+// so that values with more than 31 of them don't store the extra ones as
+// meaningful bits.  Any window the meaningful bits fit in can be reused, but
+// a wide window wastes bits on every value stored in it, so the encoder
+// resets it whenever a new one is cheaper.  Both choices decode the same
+// way, so the stream format doesn't depend on how they are made.
+//
+// Without lookahead, a new window must save more than resetMargin bits, so
+// that a value with few meaningful bits doesn't narrow a window the values
+// after it have to widen again.  With lookahead, the encoder holds back a
+// few points and picks whichever choice stores the value and the points held
+// back after it in fewer bits, assuming those are encoded without lookahead.
+
+// resetMargin is how many bits a new window must save before it replaces one
+// that fits, without lookahead
+const resetMargin = 2
+
+// WithLookahead holds back up to n points to choose XOR windows by how they
+// store the points after them as well, which saves a few more bits than the
+// default.  Held back points are written by later pushes and by Finish, and
+// aren't seen by iterators until then.  It doesn't change the format of the
+// block, and is ignored by codecs that don't use XOR windows.
+func WithLookahead(n int) Option {
+	if n < 0 {
+		panic("tsz: invalid lookahead")
+	}
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.lookahead = n
+	}
+}
+
+// WithWindowReuse reuses the XOR window of the previous value whenever the
+// next one fits in it, as this package did before choosing windows by cost.
+// It stores values in the same bits as older versions, which are more than
+// they take without it.
+func WithWindowReuse() Option {
+	return func(f *format) {
+		f.reuse = true
+	}
+}
+
+// xorWindows reports whether values are stored in XOR windows
+func (f format) xorWindows() bool {
+	switch f.codec {
+	case codecXOR, codecXORInt, codecDecimal:
+		return true
+	}
+	return false
+}
+
+// leadingBits returns the width of the leading zeros of a new window
+func (f format) leadingBits() int {
+	if f.wideLeading {
+		return 6
+	}
+	return 5
+}
+
+// windowBits returns the number of bits describing a new window
+func (f format) windowBits() int {
+	return f.leadingBits() + 6
+}
+
+// WithWideLeading stores the leading zeros of a new XOR window in 6 bits
+// rather than 5, which saves bits on large values that vary slowly, whose
+// XORs often have more than 31 leading zeros, and costs a bit on every new
+// window otherwise.  It implies WithHeader, and is ignored by codecs that
+// don't use XOR windows.
+func WithWideLeading() Option {
+	return func(f *format) {
+		if f.codec == codecInt {
+			return
+		}
+		f.framed = true
+		f.wideLeading = true
+	}
+}
+
+// xorWindow returns the leading and trailing zeros of a non-zero XOR, as
+// stored in a new window
+func (f format) xorWindow(xor uint64) (leading, trailing uint8) {
+	leading = uint8(bits.LeadingZeros64(xor))
+	trailing = uint8(bits.TrailingZeros64(xor))
+
+	// clamp number of leading zeros to avoid overflow when encoding
+	if max := uint8(1)<<uint(f.leadingBits()) - 1; leading > max {
+		leading = max
+	}
+	return leading, trailing
+}
+
+// fits reports whether meaningful bits with the given leading and trailing
+// zeros fit in the current window
+func (s *Series) fits(leading, trailing uint8) bool {
+	return s.leading != ^uint8(0) && leading >= s.leading && trailing >= s.trailing
+}
+
+// resetWindow reports whether v, whose XOR with the previous value has the
+// given leading and trailing zeros, is stored in a new window
+func (s *Series) resetWindow(v float64, leading, trailing uint8) bool {
+	if !s.fits(leading, trailing) {
+		return true
+	}
+	if s.fmt.reuse {
+		return false
+	}
+
+	reuse := 64 - int(s.leading) - int(s.trailing)
+	reset := s.fmt.windowBits() + 64 - int(leading) - int(trailing)
+	if s.fmt.lookahead == 0 {
+		return reuse > reset+resetMargin
+	}
+
+	// the points held back are the ones after v
+	reuse += s.fmt.aheadBits(s.leading, s.trailing, v, s.pending)
+	reset += s.fmt.aheadBits(leading, trailing, v, s.pending)
+	return reuse > reset
+}
+
+// aheadBits returns the number of bits the XOR codec takes to store the
+// values of points following prev, starting in the given window
+func (f format) aheadBits(leading, trailing uint8, prev float64, points []Point) int {
+	n := 0
+	for _, p := range points {
+		xor := math.Float64bits(p.V) ^ math.Float64bits(prev)
+		prev = p.V
+		if xor == 0 {
+			n++
+			continue
+		}
+
+		l, t := f.xorWindow(xor)
+		reuse := 64 - int(leading) - int(trailing)
+		reset := f.windowBits() + 64 - int(l) - int(t)
+		if leading != ^uint8(0) && l >= leading && t >= trailing && reuse <= reset+resetMargin {
+			n += 2 + reuse
+			continue
+		}
+		n += 2 + reset
+		leading, trailing = l, t
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package tsz
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookaheadPending(t *testing.T) {
+	s := New(0, WithLookahead(4))
+	for i := 0; i < 10; i++ {
+		if err := s.Push(uint32(i*60), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// points held back are checked against each other
+	if err := s.Push(8*60, 0); err != ErrOutOfOrder {
+		t.Errorf("Push(earlier than a point held back)=%v, want %v", err, ErrOutOfOrder)
+	}
+
+	count := func(it *Iter) int {
+		var n int
+		for it.Next() {
+			n++
+		}
+		return n
+	}
+	if n := count(s.Iter()); n != 6 {
+		t.Errorf("open series has %d points, want 6", n)
+	}
+	s.Finish()
+	if n := count(s.Iter()); n != 10 {
+		t.Errorf("finished series has %d points, want 10", n)
+	}
+}
+
+func TestLookaheadFiltered(t *testing.T) {
+	data := sensorData()
+
+	// holding points back doesn't change which are kept, or what they store
+	points := func(opts ...Option) []Point {
+		s := New(0, opts...)
+		for _, p := range data {
+			s.Push(uint32(p.T), p.V)
+		}
+		s.Finish()
+
+		var got []Point
+		it := s.Iter()
+		for it.Next() {
+			ts, v := it.Values64()
+			got = append(got, Point{ts, v})
+		}
+		return got
+	}
+
+	for _, opt := range []Option{WithSwingingDoor(0.5), WithRelativeError(1e-3)} {
+		want, got := points(opt), points(opt, WithLookahead(4))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d points with lookahead, want the same %d as without", len(got), len(want))
+		}
+	}
+}
+
+func TestWideLeadingHeader(t *testing.T) {
+	// the flag is dropped by codecs without XOR windows
+	s := New(0, WithChimp(), WithWideLeading())
+	if s.fmt.flags()&flagWideLeading != 0 {
+		t.Error("Chimp series has flagWideLeading set")
+	}
+
+	// and rejected in their headers
+	for _, c := range []valueCodec{codecInt, codecChimp, codecChimp128} {
+		f := format{codec: c, wideLeading: true}
+		if _, err := parseFlags(f.flags()); err != ErrBadHeader {
+			t.Errorf("codec %d with flagWideLeading: parseFlags()=%v, want %v", c, err, ErrBadHeader)
+		}
+	}
+}